@@ -33,31 +33,46 @@ func main() {
 	)
 
 	// Initialize provider
-	state.Provider = providers.NewYouTubeProvider(state.Categories.GetTrendingParameters())
+	state.Provider = providers.NewYouTubeProvider(
+		state.Categories.GetTrendingParameters(),
+		state.Config.Ratings.DislikeApiUrl,
+	)
 
 	// Initialize paths
-	staticDir, downloadDir, templatesDir, cacheDir := initializePaths(state)
+	staticDir, workDir := initializePaths(state)
 
-	// Initialize templates
-	if err := templates.Initialize(templatesDir); err != nil {
-		state.Logger.Errorf("Failed to initialize templates: %v", err)
-		os.Exit(1)
-	}
+	// Allow replacing the xml documents without a restart
+	templates.SetOverrideFolder(state.Config.Templates.OverrideFolder)
+
+	// Launch video cache eviction
+	videoCache := setupVideoCache(state, routes.DownloadBucket, routes.VideoBucket)
 
 	// Create video streamer
 	videoStreamer := routes.NewVideoStreamer(
-		downloadDir,
-		filepath.Join(cacheDir, "videos"),
+		state.Storage,
+		workDir,
 		state.Config.Video.Quality,
+		state.Config.Video.Workers,
+		state.Config.Video.DirectStream,
+		routes.NewYtdlpDownloader(state.Logger),
+		routes.NewFFmpegTranscoder(state.Logger),
+		videoCache,
 		state.Logger,
 	)
 
+	// Remove leftovers of interrupted downloads & conversions
+	videoStreamer.CleanWorkDir()
+	go videoStreamer.VerifyStorage()
+
 	// Register all routes
 	registerRoutes(server, videoStreamer, staticDir)
 
 	// Launch thumbnail cache scheduler
 	thumbnailCache := setupThumbnailCache(state)
 
+	// Launch video prefetcher
+	prefetcher := setupPrefetcher(state, videoStreamer)
+
 	// Handle graceful shutdown
 	signalChannel := make(chan os.Signal, 1)
 	signal.Notify(signalChannel, syscall.SIGINT, syscall.SIGTERM)
@@ -66,6 +81,10 @@ func main() {
 		<-signalChannel
 		state.Logger.Log("Shutting down...")
 		thumbnailCache.Stop()
+		videoCache.Stop()
+		if prefetcher != nil {
+			prefetcher.Stop()
+		}
 		os.Exit(0)
 	}()
 
@@ -76,6 +95,7 @@ func registerRoutes(server *app.Server, streamer *routes.VideoStreamer, staticDi
 	routes.RegisterStaticRoutes(server, staticDir)
 	routes.RegisterInfoRoutes(server)
 	routes.RegisterSearchRoutes(server)
+	routes.RegisterChannelRoutes(server)
 	routes.RegisterCategoryRoutes(server)
 	routes.RegisterVideoRoutes(server, streamer)
 
@@ -86,29 +106,71 @@ func registerRoutes(server *app.Server, streamer *routes.VideoStreamer, staticDi
 	})).Methods("GET")
 }
 
-func initializePaths(state *app.State) (staticDir, downloadDir, templatesDir, cacheDir string) {
-	// Get paths for static files and downloads
-	execPath, _ := os.Executable()
-	baseDir := filepath.Dir(filepath.Dir(filepath.Dir(execPath)))
-	staticDir = filepath.Join(baseDir, "static")
+func initializePaths(state *app.State) (staticDir, workDir string) {
+	workDir = resolveWorkDir(state)
+	staticDir = resolveStaticDir(state.Config.Static.Folder)
+
+	// Ensure directories exist
+	os.MkdirAll(staticDir, 0755)
+	os.MkdirAll(workDir, 0755)
+
+	return staticDir, workDir
+}
+
+// resolveWorkDir resolves the work folder, falling back to the deprecated download folder
+func resolveWorkDir(state *app.State) string {
+	if state.Config.Video.WorkFolder != "" {
+		return state.Config.Video.WorkFolder
+	}
+	if state.Config.Video.DownloadFolder != "" {
+		state.Logger.Log("DOWNLOAD_FOLDER is deprecated, use VIDEO_WORK_FOLDER instead. Finished videos are kept in the storage now")
+		return state.Config.Video.DownloadFolder
+	}
+	return "./data/work"
+}
+
+// resolveStaticDir resolves the static folder, preferring the configured one,
+// then the one next to the executable and lastly the working directory
+func resolveStaticDir(configured string) string {
+	if configured != "" {
+		return configured
+	}
 
-	// Handle running from source directory
-	if _, err := os.Stat(staticDir); os.IsNotExist(err) {
-		// Use current working directory
-		baseDir, _ = os.Getwd()
+	if execPath, err := os.Executable(); err == nil {
+		staticDir := filepath.Join(filepath.Dir(execPath), "static")
+		if info, err := os.Stat(staticDir); err == nil && info.IsDir() {
+			return staticDir
+		}
 	}
 
-	downloadDir = state.Config.Video.DownloadFolder
-	staticDir = filepath.Join(baseDir, "static")
-	templatesDir = filepath.Join(baseDir, "templates")
-	cacheDir = filepath.Join(state.Config.Storage.Path, "cache")
+	return "static"
+}
 
-	// Ensure directories exist
-	os.MkdirAll(cacheDir, 0755)
-	os.MkdirAll(staticDir, 0755)
-	os.MkdirAll(downloadDir, 0755)
+func setupVideoCache(state *app.State, buckets ...string) *app.VideoCache {
+	videoCache := app.NewVideoCache(
+		state.Storage,
+		buckets,
+		state.Config.VideoCache.MaxSize*1024*1024,
+		time.Duration(state.Config.VideoCache.MaxAge)*time.Second,
+		state.Config.VideoCache.DeleteSources,
+		state.Logger,
+	)
+	videoCache.Start()
+	return videoCache
+}
 
-	return staticDir, downloadDir, templatesDir, cacheDir
+func setupPrefetcher(state *app.State, streamer *routes.VideoStreamer) *routes.Prefetcher {
+	if state.Config.Prefetch.Count <= 0 {
+		return nil
+	}
+
+	interval := time.Duration(state.Config.Prefetch.Interval) * time.Second
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	prefetcher := routes.NewPrefetcher(state, streamer, state.Config.Prefetch.Count, interval)
+	prefetcher.Start()
+	return prefetcher
 }
 
 func setupThumbnailCache(state *app.State) *app.ThumbnailCache {
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/caarlos0/env/v11"
 	"github.com/joho/godotenv"
@@ -19,16 +20,46 @@ type Config struct {
 		Type string `env:"STORAGE_TYPE" envDefault:"local"`
 		Path string `env:"STORAGE_PATH" envDefault:"./data"`
 	}
+	Static struct {
+		Folder string `env:"STATIC_FOLDER"`
+	}
+	Templates struct {
+		OverrideFolder string `env:"TEMPLATES_OVERRIDE_FOLDER"`
+	}
 	Video struct {
-		Quality        string `env:"VIDEO_QUALITY" envDefault:"360"`
-		DownloadFolder string `env:"DOWNLOAD_FOLDER" envDefault:"./data/downloads"`
+		Quality      string `env:"VIDEO_QUALITY" envDefault:"360"`
+		WorkFolder   string `env:"VIDEO_WORK_FOLDER"`
+		Workers      int    `env:"VIDEO_WORKERS" envDefault:"2"`
+		DirectStream bool   `env:"VIDEO_DIRECT_STREAM" envDefault:"false"`
+
+		// Deprecated: replaced by WorkFolder, only used if that isn't set
+		DownloadFolder string `env:"DOWNLOAD_FOLDER"`
 	}
 	Cache struct {
 		Duration int `env:"CACHE_DURATION" envDefault:"300"`
 	}
+	VideoCache struct {
+		MaxSize       int64 `env:"VIDEO_CACHE_MAX_SIZE" envDefault:"10240"`
+		MaxAge        int   `env:"VIDEO_CACHE_MAX_AGE" envDefault:"604800"`
+		DeleteSources bool  `env:"VIDEO_CACHE_DELETE_SOURCES" envDefault:"false"`
+	}
+	Prefetch struct {
+		Count    int `env:"PREFETCH_COUNT" envDefault:"0"`
+		Interval int `env:"PREFETCH_INTERVAL" envDefault:"3600"`
+	}
+	Suggestions struct {
+		CacheDuration int `env:"SUGGESTIONS_CACHE_DURATION" envDefault:"3600"`
+		PopularCount  int `env:"SUGGESTIONS_POPULAR_COUNT" envDefault:"3"`
+	}
+	Ratings struct {
+		DislikeApiUrl string `env:"DISLIKE_API_URL"`
+	}
 	Categories struct {
 		ConfigPath string `env:"CATEGORIES_CONFIG" envDefault:"./categories.json"`
 	}
+	Profiles struct {
+		ConfigPath string `env:"PROFILES_CONFIG" envDefault:"./profiles.json"`
+	}
 }
 
 func NewConfig() *Config {
@@ -47,6 +78,8 @@ type Category struct {
 	Route          string `json:"route"`
 	TrendingParam  string `json:"trendingParam"`
 	SearchFallback string `json:"searchFallback"`
+	AudioOnly      bool   `json:"audioOnly"`
+	Loudnorm       bool   `json:"loudnorm"`
 }
 
 type CategoryListing struct {
@@ -84,6 +117,18 @@ func (c *CategoryListing) GetCategory(name string) *Category {
 	return nil
 }
 
+func (c *CategoryListing) GetVideoCategory(videoCategory string) *Category {
+	if videoCategory == "" {
+		return nil
+	}
+	for _, cat := range c.Entries {
+		if strings.EqualFold(cat.Name, videoCategory) {
+			return &cat
+		}
+	}
+	return nil
+}
+
 func (c *CategoryListing) GetTrendingParameters() map[string]string {
 	params := make(map[string]string)
 	for _, cat := range c.Entries {
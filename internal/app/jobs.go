@@ -0,0 +1,209 @@
+package app
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// JobStatus is the processing state of a job
+type JobStatus string
+
+const (
+	JobQueued      JobStatus = "queued"
+	JobDownloading JobStatus = "downloading"
+	JobConverting  JobStatus = "converting"
+	JobDone        JobStatus = "done"
+	JobFailed      JobStatus = "failed"
+)
+
+// How long finished jobs are kept around for status requests
+const jobRetention = time.Hour
+
+// Job is a background task that processes a single video format
+type Job struct {
+	ID      string
+	VideoID string
+	Format  int
+
+	mu      sync.Mutex
+	status  JobStatus
+	err     error
+	updated time.Time
+	done    chan struct{}
+}
+
+// JobInfo is a snapshot of the state of a job
+type JobInfo struct {
+	Format  int       `json:"format"`
+	Status  JobStatus `json:"status"`
+	Error   string    `json:"error,omitempty"`
+	Updated time.Time `json:"updated"`
+}
+
+// JobFunc performs the work of a job, updating its status along the way
+type JobFunc func(job *Job) error
+
+// SetStatus updates the status of a running job
+func (j *Job) SetStatus(status JobStatus) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.status = status
+	j.updated = time.Now()
+}
+
+// Info returns a snapshot of the state of the job
+func (j *Job) Info() JobInfo {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	info := JobInfo{Format: j.Format, Status: j.status, Updated: j.updated}
+	if j.err != nil {
+		info.Error = j.err.Error()
+	}
+	return info
+}
+
+// Wait blocks until the job has finished and returns its error,
+// or returns early if the given context is cancelled
+func (j *Job) Wait(ctx context.Context) error {
+	select {
+	case <-j.done:
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		return j.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Done returns a channel that is closed once the job has finished
+func (j *Job) Done() <-chan struct{} {
+	return j.done
+}
+
+// finish marks the job as done or failed and releases all waiters
+func (j *Job) finish(err error) {
+	j.mu.Lock()
+	j.err = err
+	j.status = JobDone
+	if err != nil {
+		j.status = JobFailed
+	}
+	j.updated = time.Now()
+	j.mu.Unlock()
+	close(j.done)
+}
+
+// finished returns whether the job is done or has failed
+func (j *Job) finished() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.status == JobDone || j.status == JobFailed
+}
+
+// JobManager runs jobs in the background with a bounded amount of workers,
+// making sure that every job is only processed once at a time
+type JobManager struct {
+	Logger *Logger
+
+	mu      sync.Mutex
+	jobs    map[string]*Job
+	workers chan struct{}
+}
+
+// NewJobManager creates a new job manager
+func NewJobManager(workers int, logger *Logger) *JobManager {
+	if workers <= 0 {
+		workers = 1
+	}
+	return &JobManager{
+		Logger:  logger,
+		jobs:    make(map[string]*Job),
+		workers: make(chan struct{}, workers),
+	}
+}
+
+// Submit queues a job, or returns the existing one if the same job is still queued or running
+func (m *JobManager) Submit(id string, videoID string, format int, fn JobFunc) *Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.prune()
+
+	if job, ok := m.jobs[id]; ok && !job.finished() {
+		return job
+	}
+
+	job := &Job{
+		ID:      id,
+		VideoID: videoID,
+		Format:  format,
+		status:  JobQueued,
+		updated: time.Now(),
+		done:    make(chan struct{}),
+	}
+	m.jobs[id] = job
+
+	go m.run(job, fn)
+	return job
+}
+
+// run waits for a free worker and performs the job
+func (m *JobManager) run(job *Job, fn JobFunc) {
+	m.workers <- struct{}{}
+	defer func() { <-m.workers }()
+
+	err := fn(job)
+	if err != nil {
+		m.Logger.Errorf("Job %s failed: %v", job.ID, err)
+	}
+	job.finish(err)
+}
+
+// Await waits for another job from within a running job, handing
+// the worker of the running job over to others in the meantime
+func (m *JobManager) Await(other *Job) error {
+	<-m.workers
+	defer func() { m.workers <- struct{}{} }()
+	return other.Wait(context.Background())
+}
+
+// Active returns the amount of jobs that are queued or running
+func (m *JobManager) Active() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	active := 0
+	for _, job := range m.jobs {
+		if !job.finished() {
+			active++
+		}
+	}
+	return active
+}
+
+// VideoJobs returns the jobs that belong to a video
+func (m *JobManager) VideoJobs(videoID string) []*Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var jobs []*Job
+	for _, job := range m.jobs {
+		if job.VideoID == videoID {
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs
+}
+
+// prune removes jobs that finished a while ago, expects the lock to be held
+func (m *JobManager) prune() {
+	for id, job := range m.jobs {
+		if !job.finished() {
+			continue
+		}
+		if time.Since(job.Info().Updated) > jobRetention {
+			delete(m.jobs, id)
+		}
+	}
+}
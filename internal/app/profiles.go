@@ -0,0 +1,188 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/Lekuruu/give-wii-youtube/internal/providers"
+)
+
+// Name of the built-in profile, which is used if no profiles are configured
+const DefaultProfileName = "wii"
+
+// EncodingSettings describe how videos are encoded into a container
+type EncodingSettings struct {
+	VideoCodec       string            `json:"videoCodec"`
+	VideoBitrate     int               `json:"videoBitrate"`     // In kbps, 0 to use the bitrate of the format
+	FrameRate        int               `json:"frameRate"`        // Frames per second
+	KeyframeInterval int               `json:"keyframeInterval"` // Seconds between two keyframes
+	AudioCodec       string            `json:"audioCodec"`
+	AudioBitrate     int               `json:"audioBitrate"` // In kbps, 0 to use the bitrate of the format
+	SampleRate       int               `json:"sampleRate"`   // In Hz, 0 to keep the sample rate of the source
+	AudioChannels    int               `json:"audioChannels"`
+	Options          map[string]string `json:"options"` // Additional ffmpeg output options
+}
+
+// GOP returns the amount of frames between two keyframes
+func (s EncodingSettings) GOP() int {
+	return s.FrameRate * s.KeyframeInterval
+}
+
+// withDefaults fills all unset values with the given defaults
+func (s EncodingSettings) withDefaults(defaults EncodingSettings) EncodingSettings {
+	if s.VideoCodec == "" {
+		s.VideoCodec = defaults.VideoCodec
+	}
+	if s.FrameRate <= 0 {
+		s.FrameRate = defaults.FrameRate
+	}
+	if s.KeyframeInterval <= 0 {
+		s.KeyframeInterval = defaults.KeyframeInterval
+	}
+	if s.AudioCodec == "" {
+		s.AudioCodec = defaults.AudioCodec
+	}
+	if s.SampleRate <= 0 {
+		s.SampleRate = defaults.SampleRate
+	}
+	if s.AudioChannels <= 0 {
+		s.AudioChannels = defaults.AudioChannels
+	}
+
+	options := make(map[string]string, len(defaults.Options)+len(s.Options))
+	for key, value := range defaults.Options {
+		options[key] = value
+	}
+	for key, value := range s.Options {
+		options[key] = value
+	}
+	s.Options = options
+	return s
+}
+
+// defaultEncodings are the encoding settings of the built-in profile,
+// which also fill in the values that configured profiles leave out
+var defaultEncodings = map[string]EncodingSettings{
+	"webm": {
+		VideoCodec:       "libvpx",
+		FrameRate:        30,
+		KeyframeInterval: 1,
+		AudioCodec:       "libvorbis",
+		Options:          map[string]string{"cpu-used": "8", "pix_fmt": "yuv420p"},
+	},
+	"flv": {
+		VideoCodec:       "flv1",
+		FrameRate:        24,
+		KeyframeInterval: 2,
+		AudioCodec:       "mp3",
+		SampleRate:       44100,
+	},
+}
+
+// TranscodeProfile describes the output for a type of client
+type TranscodeProfile struct {
+	Name       string                      `json:"name"`
+	UserAgents []string                    `json:"userAgents"` // Substrings of the user agents of matching clients
+	Endpoints  []string                    `json:"endpoints"`  // Path prefixes of matching requests
+	MaxHeight  int                         `json:"maxHeight"`  // Highest resolution served, 0 for no limit
+	Containers map[string]EncodingSettings `json:"containers"` // Encoding settings per container
+}
+
+// Encoding returns the format with its resolution & bitrates adjusted
+// to the profile, along with the settings used to encode it
+func (p *TranscodeProfile) Encoding(format providers.MediaFormat) (providers.MediaFormat, EncodingSettings) {
+	if p.MaxHeight > 0 && format.Height > p.MaxHeight {
+		format.Height = p.MaxHeight
+	}
+
+	settings := p.Containers[format.Container].withDefaults(defaultEncodings[format.Container])
+	if settings.VideoBitrate > 0 {
+		format.VideoBitrate = settings.VideoBitrate
+	}
+	if settings.AudioBitrate > 0 {
+		format.AudioBitrate = settings.AudioBitrate
+	}
+	settings.VideoBitrate = format.VideoBitrate
+	settings.AudioBitrate = format.AudioBitrate
+	return format, settings
+}
+
+// matches returns whether the profile was made for the client of a request
+func (p *TranscodeProfile) matches(request *http.Request) bool {
+	userAgent := strings.ToLower(request.UserAgent())
+	for _, agent := range p.UserAgents {
+		if agent != "" && strings.Contains(userAgent, strings.ToLower(agent)) {
+			return true
+		}
+	}
+	for _, endpoint := range p.Endpoints {
+		if endpoint != "" && strings.HasPrefix(request.URL.Path, endpoint) {
+			return true
+		}
+	}
+	return false
+}
+
+type ProfileListing struct {
+	Default string             `json:"default"`
+	Entries []TranscodeProfile `json:"profiles"`
+}
+
+// DefaultProfiles returns a listing with only the built-in profile
+func DefaultProfiles() *ProfileListing {
+	return &ProfileListing{
+		Default: DefaultProfileName,
+		Entries: []TranscodeProfile{{Name: DefaultProfileName}},
+	}
+}
+
+func LoadProfiles(path string) (*ProfileListing, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read profiles file: %w", err)
+	}
+
+	var config ProfileListing
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse profiles file: %w", err)
+	}
+	if len(config.Entries) == 0 {
+		return nil, fmt.Errorf("profiles file contains no profiles")
+	}
+
+	return &config, nil
+}
+
+func (l *ProfileListing) GetProfile(name string) *TranscodeProfile {
+	for i := range l.Entries {
+		if l.Entries[i].Name == name {
+			return &l.Entries[i]
+		}
+	}
+	return nil
+}
+
+// DefaultProfile returns the profile used for unknown clients
+func (l *ProfileListing) DefaultProfile() *TranscodeProfile {
+	if profile := l.GetProfile(l.Default); profile != nil {
+		return profile
+	}
+	return &l.Entries[0]
+}
+
+// Resolve selects the profile for a request, by the "profile" parameter,
+// followed by the user agent & path of the request
+func (l *ProfileListing) Resolve(request *http.Request) *TranscodeProfile {
+	if profile := l.GetProfile(request.URL.Query().Get("profile")); profile != nil {
+		return profile
+	}
+	for i := range l.Entries {
+		if l.Entries[i].matches(request) {
+			return &l.Entries[i]
+		}
+	}
+	return l.DefaultProfile()
+}
@@ -2,16 +2,19 @@ package app
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/Lekuruu/give-wii-youtube/internal/providers"
 )
 
 type State struct {
-	Config     *Config
-	Logger     *Logger
-	Storage    Storage
-	Provider   providers.Provider
-	Categories *CategoryListing
+	Config      *Config
+	Logger      *Logger
+	Storage     Storage
+	Provider    providers.Provider
+	Categories  *CategoryListing
+	Profiles    *ProfileListing
+	Suggestions *SuggestionCache
 }
 
 func NewState() *State {
@@ -40,10 +43,24 @@ func NewState() *State {
 		categories = &CategoryListing{Entries: []Category{}}
 	}
 
+	profiles, err := LoadProfiles(config.Profiles.ConfigPath)
+	if err != nil {
+		// Use the built-in profile
+		logger.Errorf("Failed to load profiles: %v", err)
+		profiles = DefaultProfiles()
+	}
+
+	suggestions := NewSuggestionCache(
+		time.Duration(config.Suggestions.CacheDuration)*time.Second,
+		config.Suggestions.PopularCount,
+	)
+
 	return &State{
-		Config:     config,
-		Logger:     logger,
-		Storage:    storage,
-		Categories: categories,
+		Config:      config,
+		Logger:      logger,
+		Storage:     storage,
+		Categories:  categories,
+		Profiles:    profiles,
+		Suggestions: suggestions,
 	}
 }
@@ -5,6 +5,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"time"
 )
 
 // Storage interface defines common operations for storage backends
@@ -13,11 +14,111 @@ type Storage interface {
 	Save(key string, bucket string, data []byte) error
 	Read(key string, bucket string) ([]byte, error)
 	ReadStream(key string, bucket string) (io.ReadSeekCloser, error)
+	WriteStream(key string, bucket string) (io.WriteCloser, error)
 	Remove(key string, bucket string) error
 	Exists(key string, bucket string) bool
+	Stat(key string, bucket string) (StorageInfo, error)
+	List(bucket string) ([]StorageInfo, error)
 	GetPath(key string, bucket string) string
 }
 
+// StorageFile references a file inside of a storage bucket
+type StorageFile struct {
+	Key    string
+	Bucket string
+}
+
+// StorageInfo describes a stored file
+type StorageInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// fileMover is implemented by storage backends that can take over local files without copying them
+type fileMover interface {
+	Move(path string, key string, bucket string) error
+}
+
+// fileLocator is implemented by storage backends that keep their files on the local disk
+type fileLocator interface {
+	LocalPath(key string, bucket string) string
+}
+
+// writeAborter is implemented by stream writers that can discard an incomplete write
+type writeAborter interface {
+	Abort() error
+}
+
+// TempSuffix is the suffix of files that are still being written
+const TempSuffix = ".tmp"
+
+// StoreFile moves a local file into a storage bucket, streaming it into
+// the storage unless the backend is able to move the file directly
+func StoreFile(storage Storage, path string, file StorageFile) error {
+	if mover, ok := storage.(fileMover); ok {
+		return mover.Move(path, file.Key, file.Bucket)
+	}
+
+	source, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	writer, err := storage.WriteStream(file.Key, file.Bucket)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(writer, source); err != nil {
+		if aborter, ok := writer.(writeAborter); ok {
+			aborter.Abort()
+		} else {
+			writer.Close()
+			storage.Remove(file.Key, file.Bucket)
+		}
+		return fmt.Errorf("failed to store file: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		storage.Remove(file.Key, file.Bucket)
+		return fmt.Errorf("failed to store file: %w", err)
+	}
+	return os.Remove(path)
+}
+
+// FetchFile returns a local path of a stored file, for tools that can only read from the disk.
+// The file is copied into dir unless the backend keeps it locally, where the returned
+// function removes the copy once it's not needed anymore.
+func FetchFile(storage Storage, file StorageFile, dir string) (string, func(), error) {
+	if locator, ok := storage.(fileLocator); ok {
+		return locator.LocalPath(file.Key, file.Bucket), func() {}, nil
+	}
+
+	source, err := storage.ReadStream(file.Key, file.Bucket)
+	if err != nil {
+		return "", nil, err
+	}
+	defer source.Close()
+
+	// Copies are named uniquely, as the same file may be fetched by multiple jobs at once
+	local, err := os.CreateTemp(dir, "*_"+filepath.Base(file.Key)+TempSuffix)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create local file: %w", err)
+	}
+	cleanup := func() { os.Remove(local.Name()) }
+
+	if _, err := io.Copy(local, source); err != nil {
+		local.Close()
+		cleanup()
+		return "", nil, fmt.Errorf("failed to fetch file: %w", err)
+	}
+	if err := local.Close(); err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("failed to fetch file: %w", err)
+	}
+	return local.Name(), cleanup, nil
+}
+
 // FileStorage implements Storage interface using the local local
 type FileStorage struct {
 	BasePath string
@@ -48,6 +149,11 @@ func (fs *FileStorage) GetPath(key, bucket string) string {
 	return fs.getFullPath(key, bucket)
 }
 
+// LocalPath returns the path of a stored file on the local disk
+func (fs *FileStorage) LocalPath(key, bucket string) string {
+	return fs.getFullPath(key, bucket)
+}
+
 func (fs *FileStorage) Save(key, bucket string, data []byte) error {
 	fullPath := fs.getFullPath(key, bucket)
 	dir := filepath.Dir(fullPath)
@@ -56,7 +162,16 @@ func (fs *FileStorage) Save(key, bucket string, data []byte) error {
 		return fmt.Errorf("failed to create directory: %w", err)
 	}
 
-	return os.WriteFile(fullPath, data, 0644)
+	// Write into a temporary file first, so that the file never appears incomplete
+	file, err := createTempFile(fullPath)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Abort()
+		return err
+	}
+	return file.Close()
 }
 
 func (fs *FileStorage) Read(key, bucket string) ([]byte, error) {
@@ -79,3 +194,117 @@ func (fs *FileStorage) Exists(key, bucket string) bool {
 	_, err := os.Stat(fullPath)
 	return err == nil
 }
+
+func (fs *FileStorage) WriteStream(key, bucket string) (io.WriteCloser, error) {
+	fullPath := fs.getFullPath(key, bucket)
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %w", err)
+	}
+	return createTempFile(fullPath)
+}
+
+func (fs *FileStorage) Stat(key, bucket string) (StorageInfo, error) {
+	stat, err := os.Stat(fs.getFullPath(key, bucket))
+	if err != nil {
+		return StorageInfo{}, err
+	}
+	return StorageInfo{Key: key, Size: stat.Size(), ModTime: stat.ModTime()}, nil
+}
+
+func (fs *FileStorage) List(bucket string) ([]StorageInfo, error) {
+	bucketPath := filepath.Join(fs.BasePath, bucket)
+	var files []StorageInfo
+
+	err := filepath.WalkDir(bucketPath, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		key, err := filepath.Rel(bucketPath, path)
+		if err != nil {
+			return nil
+		}
+		files = append(files, StorageInfo{Key: filepath.ToSlash(key), Size: info.Size(), ModTime: info.ModTime()})
+		return nil
+	})
+	return files, err
+}
+
+// Move moves a local file into the storage, copying it if it's on another device
+func (fs *FileStorage) Move(path, key, bucket string) error {
+	fullPath := fs.getFullPath(key, bucket)
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	if err := os.Rename(path, fullPath); err == nil {
+		return nil
+	}
+
+	source, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	// Copy into a temporary file first, so that the file never appears incomplete
+	target, err := createTempFile(fullPath)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(target, source); err != nil {
+		target.Abort()
+		return fmt.Errorf("failed to copy file: %w", err)
+	}
+	if err := target.Close(); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// tempFile is a file that is written next to its target path,
+// and only moved into place once it's closed
+type tempFile struct {
+	*os.File
+	target string
+}
+
+// createTempFile creates a temporary file for the given target path
+func createTempFile(target string) (*tempFile, error) {
+	file, err := os.CreateTemp(filepath.Dir(target), filepath.Base(target)+".*"+TempSuffix)
+	if err != nil {
+		return nil, err
+	}
+	return &tempFile{File: file, target: target}, nil
+}
+
+// Close closes the file and moves it to its target path
+func (f *tempFile) Close() error {
+	if err := f.File.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), f.target); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+// Abort closes the file and discards it
+func (f *tempFile) Abort() error {
+	f.File.Close()
+	return os.Remove(f.Name())
+}
@@ -0,0 +1,98 @@
+package app
+
+import (
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Time before the expiry of a stream url at which it's no longer handed out,
+// so that streams started from it can still be completed
+const streamUrlExpiryMargin = 10 * time.Minute
+
+// Lifetime of stream urls that don't contain an expiry
+const streamUrlDefaultTTL = 30 * time.Minute
+
+// StreamUrlCache caches resolved stream urls until shortly before they expire,
+// which saves resolving them again for every seek of a client
+type StreamUrlCache struct {
+	mutex   sync.Mutex
+	entries map[string]streamUrlEntry
+}
+
+type streamUrlEntry struct {
+	url     string
+	expires time.Time
+}
+
+// NewStreamUrlCache creates a new stream url cache
+func NewStreamUrlCache() *StreamUrlCache {
+	return &StreamUrlCache{entries: make(map[string]streamUrlEntry)}
+}
+
+// Get returns the cached stream url for a key, if it didn't expire yet
+func (c *StreamUrlCache) Get(key string) (string, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return "", false
+	}
+	if time.Now().After(entry.expires) {
+		delete(c.entries, key)
+		return "", false
+	}
+	return entry.url, true
+}
+
+// Store caches a stream url, using the "expire" parameter of the url for its lifetime
+func (c *StreamUrlCache) Store(key, streamUrl string) {
+	expires := streamUrlExpiry(streamUrl)
+	if time.Now().After(expires) {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.pruneExpired()
+	c.entries[key] = streamUrlEntry{url: streamUrl, expires: expires}
+}
+
+// Invalidate removes a stream url that was rejected upstream
+func (c *StreamUrlCache) Invalidate(streamUrl string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for key, entry := range c.entries {
+		if entry.url == streamUrl {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// pruneExpired removes all expired entries, expects the lock to be held
+func (c *StreamUrlCache) pruneExpired() {
+	now := time.Now()
+	for key, entry := range c.entries {
+		if now.After(entry.expires) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// streamUrlExpiry returns the time at which a stream url should no longer be used
+func streamUrlExpiry(streamUrl string) time.Time {
+	parsed, err := url.Parse(streamUrl)
+	if err != nil {
+		return time.Now().Add(streamUrlDefaultTTL)
+	}
+
+	expire, err := strconv.ParseInt(parsed.Query().Get("expire"), 10, 64)
+	if err != nil {
+		return time.Now().Add(streamUrlDefaultTTL)
+	}
+	return time.Unix(expire, 0).Add(-streamUrlExpiryMargin)
+}
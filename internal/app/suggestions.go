@@ -0,0 +1,321 @@
+package app
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Amount of suggestions returned by upstream for a single query.
+// Responses with fewer entries are considered exhaustive for their prefix.
+const suggestionsPageSize = 10
+
+// Minimum amount of filtered suggestions needed to reuse a non-exhaustive prefix
+const suggestionsMinReuse = 5
+
+// Upper bound of cached upstream responses before expired entries get pruned
+const suggestionsPruneThreshold = 10000
+
+// Upper bound of distinct queries tracked in the local search history
+const suggestionsMaxHistory = 50000
+
+// SuggestionCache caches upstream search suggestions in a prefix trie
+// and keeps track of queries that were searched for on this server
+type SuggestionCache struct {
+	ttl          time.Duration
+	popularCount int
+
+	mutex      sync.Mutex
+	entries    int
+	nextExpiry time.Time
+	queries    int
+	locales    map[string]*suggestionNode
+	history    *suggestionNode
+}
+
+type suggestionNode struct {
+	children    map[rune]*suggestionNode
+	suggestions []string
+	expires     time.Time
+
+	// Local search history, where every node keeps the most
+	// searched queries of its subtree, ordered by popularity
+	query    string
+	searches int
+	popular  []*suggestionNode
+}
+
+// NewSuggestionCache creates a new suggestion cache
+func NewSuggestionCache(ttl time.Duration, popularCount int) *SuggestionCache {
+	return &SuggestionCache{
+		ttl:          ttl,
+		popularCount: popularCount,
+		locales:      make(map[string]*suggestionNode),
+		history:      newSuggestionNode(),
+	}
+}
+
+func newSuggestionNode() *suggestionNode {
+	return &suggestionNode{children: make(map[rune]*suggestionNode)}
+}
+
+// child returns the child node for a rune, creating it if necessary
+func (n *suggestionNode) child(r rune) *suggestionNode {
+	next, ok := n.children[r]
+	if !ok {
+		next = newSuggestionNode()
+		n.children[r] = next
+	}
+	return next
+}
+
+// normalizeSuggestionQuery normalizes a query for use as a trie key
+func normalizeSuggestionQuery(query string) string {
+	return strings.ToLower(query)
+}
+
+// Lookup returns cached suggestions for a query, either from an exact match
+// or by filtering the response of a previously cached prefix of the query
+func (sc *SuggestionCache) Lookup(query, country, language string) ([]string, bool) {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+
+	root, ok := sc.locales[country+"-"+language]
+	if !ok {
+		return nil, false
+	}
+
+	query = normalizeSuggestionQuery(query)
+	now := time.Now()
+	node := root
+
+	var ancestor *suggestionNode
+	for _, r := range query {
+		next, ok := node.children[r]
+		if !ok {
+			node = nil
+			break
+		}
+		node = next
+
+		if node.suggestions != nil && now.Before(node.expires) {
+			ancestor = node
+		}
+	}
+
+	if ancestor == nil {
+		return nil, false
+	}
+
+	// Exact match for the query
+	if ancestor == node {
+		return ancestor.suggestions, true
+	}
+
+	filtered := make([]string, 0, len(ancestor.suggestions))
+	for _, suggestion := range ancestor.suggestions {
+		if strings.HasPrefix(strings.ToLower(suggestion), query) {
+			filtered = append(filtered, suggestion)
+		}
+	}
+
+	exhaustive := len(ancestor.suggestions) < suggestionsPageSize
+	if !exhaustive && len(filtered) < suggestionsMinReuse {
+		return nil, false
+	}
+	return filtered, true
+}
+
+// Store caches the upstream suggestions for a query
+func (sc *SuggestionCache) Store(query, country, language string, suggestions []string) {
+	if sc.ttl <= 0 {
+		return
+	}
+
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+
+	// Pruning walks the whole trie, so it's skipped until an entry has actually expired
+	now := time.Now()
+	if sc.entries >= suggestionsPruneThreshold && !now.Before(sc.nextExpiry) {
+		sc.pruneExpired()
+	}
+
+	locale := country + "-" + language
+	root, ok := sc.locales[locale]
+	if !ok {
+		root = newSuggestionNode()
+		sc.locales[locale] = root
+	}
+
+	node := root
+	for _, r := range normalizeSuggestionQuery(query) {
+		node = node.child(r)
+	}
+
+	if node.suggestions == nil {
+		sc.entries++
+	}
+	if suggestions == nil {
+		suggestions = []string{}
+	}
+	node.suggestions = suggestions
+	node.expires = now.Add(sc.ttl)
+	if sc.nextExpiry.IsZero() || node.expires.Before(sc.nextExpiry) {
+		sc.nextExpiry = node.expires
+	}
+}
+
+// RecordSearch adds a query to the local search history
+func (sc *SuggestionCache) RecordSearch(query string) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return
+	}
+
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+
+	node := sc.history
+	path := []*suggestionNode{node}
+	for _, r := range normalizeSuggestionQuery(query) {
+		next, ok := node.children[r]
+		if !ok {
+			if sc.queries >= suggestionsMaxHistory {
+				// History is full, only keep counting known queries
+				return
+			}
+			next = newSuggestionNode()
+			node.children[r] = next
+		}
+		node = next
+		path = append(path, node)
+	}
+
+	if node.searches == 0 {
+		sc.queries++
+	}
+	node.query = query
+	node.searches++
+
+	for _, prefix := range path {
+		prefix.updatePopular(node, sc.popularCount)
+	}
+}
+
+// updatePopular moves a query, whose amount of searches has increased,
+// into the most searched queries of a node
+func (n *suggestionNode) updatePopular(query *suggestionNode, count int) {
+	if count <= 0 {
+		return
+	}
+
+	found := false
+	for _, popular := range n.popular {
+		if popular == query {
+			found = true
+			break
+		}
+	}
+	if !found {
+		if len(n.popular) >= count && !morePopular(query, n.popular[len(n.popular)-1]) {
+			return
+		}
+		n.popular = append(n.popular, query)
+	}
+
+	sort.Slice(n.popular, func(i, j int) bool {
+		return morePopular(n.popular[i], n.popular[j])
+	})
+	if len(n.popular) > count {
+		n.popular = n.popular[:count]
+	}
+}
+
+// morePopular returns whether a query was searched more often than another one
+func morePopular(a, b *suggestionNode) bool {
+	if a.searches == b.searches {
+		return a.query < b.query
+	}
+	return a.searches > b.searches
+}
+
+// Merge prepends the most popular local queries matching the prefix to the
+// given suggestions, removing duplicates
+func (sc *SuggestionCache) Merge(query string, suggestions []string) []string {
+	popular := sc.popular(query)
+	if len(popular) == 0 {
+		return suggestions
+	}
+
+	merged := make([]string, 0, len(popular)+len(suggestions))
+	seen := make(map[string]bool)
+
+	for _, list := range [][]string{popular, suggestions} {
+		for _, suggestion := range list {
+			key := strings.ToLower(suggestion)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			merged = append(merged, suggestion)
+		}
+	}
+
+	return merged
+}
+
+// popular returns the most searched local queries starting with the prefix
+func (sc *SuggestionCache) popular(prefix string) []string {
+	if sc.popularCount <= 0 {
+		return nil
+	}
+
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+
+	node := sc.history
+	for _, r := range normalizeSuggestionQuery(prefix) {
+		next, ok := node.children[r]
+		if !ok {
+			return nil
+		}
+		node = next
+	}
+
+	queries := make([]string, len(node.popular))
+	for i, popular := range node.popular {
+		queries[i] = popular.query
+	}
+	return queries
+}
+
+// pruneExpired removes all expired upstream responses from the trie
+func (sc *SuggestionCache) pruneExpired() {
+	now := time.Now()
+	sc.nextExpiry = time.Time{}
+
+	var prune func(n *suggestionNode) bool
+	prune = func(n *suggestionNode) bool {
+		if n.suggestions != nil && !now.Before(n.expires) {
+			n.suggestions = nil
+			sc.entries--
+		}
+		if n.suggestions != nil && (sc.nextExpiry.IsZero() || n.expires.Before(sc.nextExpiry)) {
+			sc.nextExpiry = n.expires
+		}
+		for r, child := range n.children {
+			if prune(child) {
+				delete(n.children, r)
+			}
+		}
+		return n.suggestions == nil && len(n.children) == 0
+	}
+
+	for locale, root := range sc.locales {
+		if prune(root) {
+			delete(sc.locales, locale)
+		}
+	}
+}
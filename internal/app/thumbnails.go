@@ -32,6 +32,8 @@ func NewThumbnailCache(cacheDir string, logger *Logger, interval time.Duration,
 
 // Start begins the thumbnail update scheduler
 func (tc *ThumbnailCache) Start(categories []string, getFirstVideoID func(category string) string) {
+	tc.removeTempFiles()
+
 	go func() {
 		ticker := time.NewTicker(tc.interval)
 		defer ticker.Stop()
@@ -84,13 +86,24 @@ func (tc *ThumbnailCache) downloadThumbnail(url, category string) error {
 		return fmt.Errorf("HTTP %d", resp.StatusCode)
 	}
 
+	// Write into a temporary file first, so that the thumbnail is replaced at once
 	filePath := filepath.Join(tc.CacheDir, category+".jpg")
-	file, err := os.Create(filePath)
+	file, err := createTempFile(filePath)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
 
-	_, err = io.Copy(file, resp.Body)
-	return err
+	if _, err := io.Copy(file, resp.Body); err != nil {
+		file.Abort()
+		return err
+	}
+	return file.Close()
+}
+
+// removeTempFiles removes thumbnails of downloads that were interrupted by a crash
+func (tc *ThumbnailCache) removeTempFiles() {
+	tempFiles, _ := filepath.Glob(filepath.Join(tc.CacheDir, "*"+TempSuffix))
+	for _, path := range tempFiles {
+		os.Remove(path)
+	}
 }
@@ -0,0 +1,238 @@
+package app
+
+import (
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Interval in which the video cache is checked for expired files
+const videoCacheInterval = time.Minute
+
+// VideoCache keeps track of downloaded & converted video files,
+// and evicts the least recently used ones once the cache gets too big
+type VideoCache struct {
+	Storage       Storage
+	Buckets       []string
+	Logger        *Logger
+	MaxSize       int64         // Maximum size of all files in bytes, 0 for no limit
+	MaxAge        time.Duration // Maximum time since the last access of a file, 0 for no limit
+	DeleteSources bool          // Whether source downloads are deleted after a successful conversion
+
+	mu       sync.Mutex
+	entries  map[StorageFile]*videoCacheEntry
+	size     int64
+	stopChan chan struct{}
+}
+
+type videoCacheEntry struct {
+	size       int64
+	lastAccess time.Time
+	users      int // Amount of requests or jobs currently using the file
+}
+
+// NewVideoCache creates a new video cache for the given storage buckets
+func NewVideoCache(storage Storage, buckets []string, maxSize int64, maxAge time.Duration, deleteSources bool, logger *Logger) *VideoCache {
+	return &VideoCache{
+		Storage:       storage,
+		Buckets:       buckets,
+		Logger:        logger,
+		MaxSize:       maxSize,
+		MaxAge:        maxAge,
+		DeleteSources: deleteSources,
+		entries:       make(map[StorageFile]*videoCacheEntry),
+		stopChan:      make(chan struct{}),
+	}
+}
+
+// Start rebuilds the index from disk and begins the eviction scheduler
+func (vc *VideoCache) Start() {
+	vc.rebuild()
+	vc.Evict()
+
+	go func() {
+		ticker := time.NewTicker(videoCacheInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				vc.Evict()
+			case <-vc.stopChan:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the eviction scheduler
+func (vc *VideoCache) Stop() {
+	close(vc.stopChan)
+}
+
+// rebuild indexes all files that are already in the storage,
+// using their modification time as the last access
+func (vc *VideoCache) rebuild() {
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+
+	removed := 0
+	for _, bucket := range vc.Buckets {
+		files, err := vc.Storage.List(bucket)
+		if err != nil {
+			vc.Logger.Errorf("Failed to index cached videos in %s: %v", bucket, err)
+			continue
+		}
+		for _, info := range files {
+			file := StorageFile{Key: info.Key, Bucket: bucket}
+			if isIncompleteFile(info.Key) {
+				// Leftovers of writes that were interrupted by a crash
+				vc.remove(file)
+				removed++
+				continue
+			}
+			vc.add(file, info.Size, info.ModTime)
+		}
+	}
+	if removed > 0 {
+		vc.Logger.Logf("Removed %d incomplete cached videos", removed)
+	}
+	vc.Logger.Logf("Indexed %d cached videos (%d MB)", len(vc.entries), vc.size/1024/1024)
+}
+
+// isIncompleteFile returns whether a file is a partial download or conversion
+func isIncompleteFile(key string) bool {
+	return strings.HasSuffix(key, ".part") || strings.HasSuffix(key, TempSuffix) || strings.HasSuffix(key, ".ytdl")
+}
+
+// Size returns the size of all cached files in bytes
+func (vc *VideoCache) Size() int64 {
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+	return vc.size
+}
+
+// Add registers a new file in the cache and evicts old files if needed
+func (vc *VideoCache) Add(file StorageFile) {
+	info, err := vc.Storage.Stat(file.Key, file.Bucket)
+	if err != nil {
+		return
+	}
+
+	vc.mu.Lock()
+	vc.add(file, info.Size, time.Now())
+	vc.mu.Unlock()
+
+	vc.Evict()
+}
+
+// add registers a file, expects the lock to be held
+func (vc *VideoCache) add(file StorageFile, size int64, lastAccess time.Time) {
+	entry, ok := vc.entries[file]
+	if !ok {
+		entry = &videoCacheEntry{}
+		vc.entries[file] = entry
+	}
+	vc.size += size - entry.size
+	entry.size = size
+	entry.lastAccess = lastAccess
+}
+
+// Acquire marks a file as in use, which protects it from being evicted until it's released
+func (vc *VideoCache) Acquire(file StorageFile) {
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+
+	entry, ok := vc.entries[file]
+	if !ok {
+		entry = &videoCacheEntry{}
+		vc.entries[file] = entry
+	}
+	entry.users++
+	entry.lastAccess = time.Now()
+}
+
+// Release marks a file as no longer used by the caller
+func (vc *VideoCache) Release(file StorageFile) {
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+
+	entry, ok := vc.entries[file]
+	if !ok {
+		return
+	}
+	entry.users--
+	entry.lastAccess = time.Now()
+
+	// Files that were never added, e.g. failed conversions, are forgotten again
+	if entry.users <= 0 && entry.size == 0 {
+		delete(vc.entries, file)
+	}
+}
+
+// RemoveSource deletes a source download after it was converted, if configured.
+// It's called by the converting job while it still holds its own reference to the file,
+// so the file is only kept if anyone else is using it as well.
+func (vc *VideoCache) RemoveSource(file StorageFile) {
+	if !vc.DeleteSources {
+		return
+	}
+
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+
+	if entry, ok := vc.entries[file]; ok && entry.users > 1 {
+		return
+	}
+	vc.remove(file)
+}
+
+// Remove deletes a file that turned out to be invalid, even if it's in use
+func (vc *VideoCache) Remove(file StorageFile) {
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+	vc.remove(file)
+}
+
+// Evict removes expired files, followed by the least recently used
+// files until the cache fits into its maximum size
+func (vc *VideoCache) Evict() {
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+
+	files := make([]StorageFile, 0, len(vc.entries))
+	for file := range vc.entries {
+		files = append(files, file)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return vc.entries[files[i]].lastAccess.Before(vc.entries[files[j]].lastAccess)
+	})
+
+	for _, file := range files {
+		entry := vc.entries[file]
+		if entry.users > 0 {
+			continue
+		}
+
+		expired := vc.MaxAge > 0 && time.Since(entry.lastAccess) > vc.MaxAge
+		oversized := vc.MaxSize > 0 && vc.size > vc.MaxSize
+		if !expired && !oversized {
+			continue
+		}
+		vc.remove(file)
+	}
+}
+
+// remove deletes a file from the storage and the index, expects the lock to be held
+func (vc *VideoCache) remove(file StorageFile) {
+	if err := vc.Storage.Remove(file.Key, file.Bucket); err != nil && !os.IsNotExist(err) {
+		vc.Logger.Errorf("Failed to remove cached video %s/%s: %v", file.Bucket, file.Key, err)
+		return
+	}
+	if entry, ok := vc.entries[file]; ok {
+		vc.size -= entry.size
+		delete(vc.entries, file)
+	}
+}
@@ -0,0 +1,9 @@
+package providers
+
+import "errors"
+
+// Errors returned by providers, used to pick the status code of error responses
+var (
+	ErrNotFound    = errors.New("resource not found")
+	ErrRateLimited = errors.New("rate limited by upstream")
+)
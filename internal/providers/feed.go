@@ -0,0 +1,131 @@
+package providers
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Default amount of entries per feed page
+const DefaultItemsPerPage = 20
+
+// Upper bound for the "max-results" parameter, same as the original api
+const MaxItemsPerPage = 50
+
+// FeedOptions describes the metadata of a generated feed
+type FeedOptions struct {
+	BaseURL string     // Public url of this server
+	Path    string     // Request path of the feed, e.g. "/feeds/api/videos"
+	Query   url.Values // Query parameters of the request, used to build the feed links
+
+	ID       string // Unique feed id, e.g. "tag:youtube.com,2008:videos"
+	Title    string
+	Kind     string // Kind of the entries inside the feed
+	Category string // Category label of the entries, e.g. "Music"
+
+	StartIndex   int
+	ItemsPerPage int
+	TotalResults int
+	HasNext      bool
+
+	// Stream formats advertised for video entries
+	Formats []MediaFormat
+}
+
+// pageURL returns the url of this feed, starting at the given index
+func (o *FeedOptions) pageURL(startIndex int) string {
+	query := url.Values{}
+	for key, values := range o.Query {
+		query[key] = values
+	}
+	query.Set("start-index", strconv.Itoa(startIndex))
+	query.Set("max-results", strconv.Itoa(o.ItemsPerPage))
+	return o.FeedURL() + "?" + query.Encode()
+}
+
+// FeedURL returns the url of this feed without any parameters
+func (o *FeedOptions) FeedURL() string {
+	return strings.TrimSuffix(o.BaseURL, "/") + o.Path
+}
+
+// SelfURL returns the url of the current page
+func (o *FeedOptions) SelfURL() string {
+	return o.pageURL(o.StartIndex)
+}
+
+// NextURL returns the url of the next page, if there is one
+func (o *FeedOptions) NextURL() string {
+	if !o.HasNext {
+		return ""
+	}
+	return o.pageURL(o.StartIndex + o.ItemsPerPage)
+}
+
+// PreviousURL returns the url of the previous page, if there is one
+func (o *FeedOptions) PreviousURL() string {
+	if o.StartIndex <= 1 {
+		return ""
+	}
+	return o.pageURL(max(1, o.StartIndex-o.ItemsPerPage))
+}
+
+// kindTerm returns the gdata kind term for a result kind
+func kindTerm(kind string) string {
+	switch kind {
+	case KindChannel:
+		return "http://gdata.youtube.com/schemas/2007#channel"
+	case KindPlaylist:
+		return "http://gdata.youtube.com/schemas/2007#playlistLink"
+	default:
+		return "http://gdata.youtube.com/schemas/2007#video"
+	}
+}
+
+// formatTimestamp formats a timestamp in the format used by atom feeds
+func formatTimestamp(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
+// Units used in relative publish texts, e.g. "3 weeks ago"
+var relativeTimeUnits = map[string]time.Duration{
+	"second": time.Second,
+	"minute": time.Minute,
+	"hour":   time.Hour,
+	"day":    24 * time.Hour,
+	"week":   7 * 24 * time.Hour,
+	"month":  30 * 24 * time.Hour,
+	"year":   365 * 24 * time.Hour,
+}
+
+// ParsePublishedText parses publish dates like "2023-05-01" or relative texts
+// like "Streamed 3 days ago" into a timestamp, falling back to the given time
+func ParsePublishedText(text string, now time.Time) time.Time {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return now
+	}
+
+	if t, err := time.Parse(time.RFC3339, text); err == nil {
+		return t
+	}
+	if t, err := time.Parse(time.DateOnly, text); err == nil {
+		return t
+	}
+
+	fields := strings.Fields(strings.ToLower(text))
+	for i := 0; i < len(fields)-1; i++ {
+		amount, err := strconv.Atoi(fields[i])
+		if err != nil {
+			continue
+		}
+
+		unit, ok := relativeTimeUnits[strings.TrimSuffix(fields[i+1], "s")]
+		if !ok {
+			continue
+		}
+		return now.Add(-time.Duration(amount) * unit)
+	}
+
+	return now
+}
@@ -0,0 +1,165 @@
+package providers
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MediaFormat describes a stream format that is served by this server
+type MediaFormat struct {
+	Itag         int
+	MimeType     string
+	Container    string // File extension of the format, e.g. "webm"
+	Height       int
+	VideoBitrate int    // Target video bitrate in kbit/s
+	AudioBitrate int    // Target audio bitrate in kbit/s
+	Live         bool   // Transcoded while streaming, instead of being converted ahead of time
+	Path         string // Endpoint path, formatted with the video id
+	IsDefault    bool
+}
+
+// URL returns the url of the format for a video
+func (f MediaFormat) URL(baseURL string, videoID string) string {
+	return strings.TrimSuffix(baseURL, "/") + fmt.Sprintf(f.Path, videoID)
+}
+
+// Formats known to the legacy players, ordered by container & quality
+var knownFormats = []MediaFormat{
+	{Itag: 5, MimeType: "video/x-flv", Container: "flv", Height: 240, VideoBitrate: 500, AudioBitrate: 96, Live: true},
+	{Itag: 34, MimeType: "video/x-flv", Container: "flv", Height: 360, VideoBitrate: 800, AudioBitrate: 128, Live: true},
+	{Itag: 18, MimeType: "video/mp4", Container: "mp4", Height: 360},
+	{Itag: 43, MimeType: "video/webm", Container: "webm", Height: 360, VideoBitrate: 300, AudioBitrate: 128},
+	{Itag: 44, MimeType: "video/webm", Container: "webm", Height: 480, VideoBitrate: 500, AudioBitrate: 128},
+	{Itag: 45, MimeType: "video/webm", Container: "webm", Height: 720, VideoBitrate: 1000, AudioBitrate: 128},
+}
+
+// GetMediaFormats returns the stream formats served for the configured video quality.
+// Formats above the configured quality are left out, except for the lowest format of
+// each container, which gets scaled down to the configured quality instead.
+func GetMediaFormats(quality string) []MediaFormat {
+	maxHeight, err := strconv.Atoi(quality)
+	if err != nil {
+		maxHeight = 360
+	}
+
+	var formats []MediaFormat
+	seenContainers := make(map[string]bool)
+
+	for _, format := range knownFormats {
+		if format.Height > maxHeight {
+			if seenContainers[format.Container] {
+				continue
+			}
+			format.Height = maxHeight
+		}
+		seenContainers[format.Container] = true
+
+		endpoint := "/get_video"
+		if format.Live {
+			endpoint = "/git_video"
+		}
+		format.Path = fmt.Sprintf("%s?video_id=%%s&fmt=%d", endpoint, format.Itag)
+		format.IsDefault = format.Itag == 5
+		formats = append(formats, format)
+	}
+
+	// Converted webm files can also be fetched directly, either from cache or freshly converted
+	if webm, ok := DefaultMediaFormat(formats, false); ok {
+		webm.Path = "/videos/%s.webm"
+		webm.IsDefault = false
+		formats = append(formats, webm)
+	}
+
+	return formats
+}
+
+// MediaFormatsWithQuery returns the formats with the query appended to their paths, e.g. to request
+// audio-only playback. Mp4 files are served as downloaded, so their paths stay unchanged.
+func MediaFormatsWithQuery(formats []MediaFormat, query url.Values) []MediaFormat {
+	result := make([]MediaFormat, len(formats))
+	for i, format := range formats {
+		if format.Container != "mp4" && len(query) > 0 {
+			separator := "?"
+			if strings.Contains(format.Path, "?") {
+				separator = "&"
+			}
+			format.Path += separator + query.Encode()
+		}
+		result[i] = format
+	}
+	return result
+}
+
+// FindMediaFormat returns the format with the given itag
+func FindMediaFormat(formats []MediaFormat, itag int) (MediaFormat, bool) {
+	for _, format := range formats {
+		if format.Itag == itag {
+			return format, true
+		}
+	}
+	return MediaFormat{}, false
+}
+
+// DefaultMediaFormat returns the format used when a client doesn't request one,
+// which is the default flv stream for live formats and the best webm otherwise
+func DefaultMediaFormat(formats []MediaFormat, live bool) (MediaFormat, bool) {
+	var result MediaFormat
+	found := false
+
+	for _, format := range formats {
+		if format.Live != live {
+			continue
+		}
+		if live && format.IsDefault {
+			return format, true
+		}
+		if !live && format.Container == "webm" && (!found || format.Height > result.Height) {
+			result = format
+			found = true
+		}
+	}
+	return result, found
+}
+
+// Resolution returns the 16:9 frame size of the format, e.g. "640x360"
+func (f MediaFormat) Resolution() string {
+	width := (f.Height*16/9 + 1) / 2 * 2
+	return fmt.Sprintf("%dx%d", width, f.Height)
+}
+
+// QualityLabel returns the legacy quality name of the format, e.g. "medium"
+func (f MediaFormat) QualityLabel() string {
+	switch {
+	case f.Height >= 1080:
+		return "hd1080"
+	case f.Height >= 720:
+		return "hd720"
+	case f.Height >= 480:
+		return "large"
+	case f.Height >= 360:
+		return "medium"
+	default:
+		return "small"
+	}
+}
+
+// uniqueFormats returns the formats with distinct itags, best quality first
+func uniqueFormats(formats []MediaFormat) []MediaFormat {
+	seen := make(map[int]bool)
+	unique := make([]MediaFormat, 0, len(formats))
+	for _, format := range formats {
+		if seen[format.Itag] {
+			continue
+		}
+		seen[format.Itag] = true
+		unique = append(unique, format)
+	}
+
+	sort.SliceStable(unique, func(i, j int) bool {
+		return unique[i].Height > unique[j].Height
+	})
+	return unique
+}
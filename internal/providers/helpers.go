@@ -168,3 +168,25 @@ func extractThumbnails(m map[string]interface{}) []Thumbnail {
 
 	return thumbnails
 }
+
+// findValue recursively searches a decoded json value for the first occurrence of a key
+func findValue(v interface{}, key string) (interface{}, bool) {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		if found, ok := value[key]; ok {
+			return found, true
+		}
+		for _, child := range value {
+			if found, ok := findValue(child, key); ok {
+				return found, true
+			}
+		}
+	case []interface{}:
+		for _, child := range value {
+			if found, ok := findValue(child, key); ok {
+				return found, true
+			}
+		}
+	}
+	return nil, false
+}
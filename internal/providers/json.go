@@ -0,0 +1,501 @@
+package providers
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// jsonNamespaces holds the namespace declarations of the root element in legacy json documents
+type jsonNamespaces struct {
+	Atom       string `json:"xmlns"`
+	OpenSearch string `json:"xmlns$openSearch,omitempty"`
+	Media      string `json:"xmlns$media"`
+	GData      string `json:"xmlns$gd"`
+	YouTube    string `json:"xmlns$yt"`
+}
+
+// newJSONNamespaces returns the namespaces used in the atom documents
+func newJSONNamespaces(openSearch bool) *jsonNamespaces {
+	namespaces := &jsonNamespaces{
+		Atom:    "http://www.w3.org/2005/Atom",
+		Media:   "http://search.yahoo.com/mrss/",
+		GData:   "http://schemas.google.com/g/2005",
+		YouTube: "http://gdata.youtube.com/schemas/2007",
+	}
+	if openSearch {
+		namespaces.OpenSearch = "http://a9.com/-/spec/opensearch/1.1/"
+	}
+	return namespaces
+}
+
+// jsonText is the legacy json representation of an element with text content
+type jsonText struct {
+	Value interface{} `json:"$t"`
+	Type  string      `json:"type,omitempty"`
+}
+
+type jsonLink struct {
+	Rel  string `json:"rel"`
+	Type string `json:"type"`
+	Href string `json:"href"`
+}
+
+type jsonCategory struct {
+	Scheme string `json:"scheme"`
+	Term   string `json:"term"`
+	Label  string `json:"label,omitempty"`
+}
+
+type jsonAuthor struct {
+	Name   jsonText  `json:"name"`
+	URI    jsonText  `json:"uri"`
+	UserID *jsonText `json:"yt$userId,omitempty"`
+}
+
+type jsonFeedLink struct {
+	Rel       string `json:"rel"`
+	Href      string `json:"href"`
+	CountHint int64  `json:"countHint"`
+}
+
+type jsonThumbnail struct {
+	URL    string `json:"url"`
+	Width  int    `json:"width,omitempty"`
+	Height int    `json:"height,omitempty"`
+	Name   string `json:"yt$name,omitempty"`
+}
+
+type jsonMediaGroup struct {
+	Category    []jsonCategory  `json:"media$category,omitempty"`
+	Content     []jsonContent   `json:"media$content,omitempty"`
+	Description *jsonText       `json:"media$description,omitempty"`
+	Keywords    *jsonText       `json:"media$keywords,omitempty"`
+	Player      *jsonPlayer     `json:"media$player,omitempty"`
+	Thumbnail   []jsonThumbnail `json:"media$thumbnail"`
+	Title       *jsonText       `json:"media$title,omitempty"`
+	Duration    *jsonDuration   `json:"yt$duration,omitempty"`
+	Uploaded    *jsonText       `json:"yt$uploaded,omitempty"`
+	UploaderID  *jsonText       `json:"yt$uploaderId,omitempty"`
+	VideoID     *jsonText       `json:"yt$videoid,omitempty"`
+}
+
+type jsonContent struct {
+	URL        string `json:"url"`
+	Type       string `json:"type"`
+	Medium     string `json:"medium"`
+	IsDefault  string `json:"isDefault,omitempty"`
+	Expression string `json:"expression"`
+	Duration   int    `json:"duration"`
+	Format     int    `json:"yt$format"`
+}
+
+type jsonPlayer struct {
+	URL string `json:"url"`
+}
+
+type jsonDuration struct {
+	Seconds string `json:"seconds"`
+}
+
+type jsonStatistics struct {
+	FavoriteCount string `json:"favoriteCount"`
+	ViewCount     string `json:"viewCount"`
+	LikeCount     string `json:"likeCount,omitempty"`
+}
+
+type jsonRating struct {
+	Average   float64 `json:"average"`
+	Max       int     `json:"max"`
+	Min       int     `json:"min"`
+	NumRaters int64   `json:"numRaters"`
+	Rel       string  `json:"rel"`
+}
+
+type jsonYouTubeRating struct {
+	NumDislikes string `json:"numDislikes"`
+	NumLikes    string `json:"numLikes"`
+}
+
+type jsonChannelStatistics struct {
+	SubscriberCount string `json:"subscriberCount"`
+	VideoCount      string `json:"videoCount"`
+}
+
+type jsonEntry struct {
+	*jsonNamespaces
+	ID                jsonText               `json:"id"`
+	Published         *jsonText              `json:"published,omitempty"`
+	Updated           jsonText               `json:"updated"`
+	Category          []jsonCategory         `json:"category"`
+	Title             jsonText               `json:"title"`
+	Content           *jsonText              `json:"content,omitempty"`
+	Summary           *jsonText              `json:"summary,omitempty"`
+	Link              []jsonLink             `json:"link"`
+	Author            []jsonAuthor           `json:"author"`
+	FeedLink          []jsonFeedLink         `json:"gd$feedLink,omitempty"`
+	MediaGroup        *jsonMediaGroup        `json:"media$group,omitempty"`
+	Rating            *jsonRating            `json:"gd$rating,omitempty"`
+	Statistics        *jsonStatistics        `json:"yt$statistics,omitempty"`
+	YouTubeRating     *jsonYouTubeRating     `json:"yt$rating,omitempty"`
+	ChannelStatistics *jsonChannelStatistics `json:"yt$channelStatistics,omitempty"`
+	ChannelID         *jsonText              `json:"yt$channelId,omitempty"`
+	PlaylistID        *jsonText              `json:"yt$playlistId,omitempty"`
+}
+
+type jsonFeed struct {
+	*jsonNamespaces
+	ID           jsonText       `json:"id"`
+	Updated      jsonText       `json:"updated"`
+	Category     []jsonCategory `json:"category"`
+	Title        jsonText       `json:"title"`
+	Logo         jsonText       `json:"logo"`
+	Link         []jsonLink     `json:"link"`
+	Author       []jsonAuthor   `json:"author"`
+	TotalResults jsonText       `json:"openSearch$totalResults"`
+	StartIndex   jsonText       `json:"openSearch$startIndex"`
+	ItemsPerPage jsonText       `json:"openSearch$itemsPerPage"`
+	Entry        []jsonEntry    `json:"entry"`
+}
+
+// jsoncItem is the json-c representation of a feed entry
+type jsoncItem struct {
+	ID              string            `json:"id"`
+	Uploaded        string            `json:"uploaded,omitempty"`
+	Updated         string            `json:"updated,omitempty"`
+	Uploader        string            `json:"uploader,omitempty"`
+	Author          string            `json:"author,omitempty"`
+	Category        string            `json:"category,omitempty"`
+	Title           string            `json:"title"`
+	Description     string            `json:"description,omitempty"`
+	Tags            []string          `json:"tags,omitempty"`
+	Thumbnail       map[string]string `json:"thumbnail,omitempty"`
+	Player          map[string]string `json:"player,omitempty"`
+	Content         map[string]string `json:"content,omitempty"`
+	Duration        int               `json:"duration,omitempty"`
+	Size            int64             `json:"size,omitempty"`
+	ViewCount       *int64            `json:"viewCount,omitempty"`
+	Rating          float64           `json:"rating,omitempty"`
+	LikeCount       string            `json:"likeCount,omitempty"`
+	RatingCount     int64             `json:"ratingCount,omitempty"`
+	FavoriteCount   *int              `json:"favoriteCount,omitempty"`
+	SubscriberCount *int64            `json:"subscriberCount,omitempty"`
+}
+
+type jsoncFeed struct {
+	Updated      string      `json:"updated"`
+	TotalItems   int         `json:"totalItems"`
+	StartIndex   int         `json:"startIndex"`
+	ItemsPerPage int         `json:"itemsPerPage"`
+	Items        []jsoncItem `json:"items"`
+}
+
+// GenerateFeedJSON generates a feed in the legacy json format (alt=json)
+func GenerateFeedJSON(results []SearchResult, thumbnailFormat string, options FeedOptions) (string, error) {
+	data := newFeedTemplateData(results, thumbnailFormat, options)
+
+	links := []jsonLink{
+		{Rel: "alternate", Type: "text/html", Href: "http://www.youtube.com"},
+		{Rel: "http://schemas.google.com/g/2005#feed", Type: "application/atom+xml", Href: data.FeedURL},
+		{Rel: "self", Type: "application/atom+xml", Href: data.SelfURL},
+	}
+	if data.PreviousURL != "" {
+		links = append(links, jsonLink{Rel: "previous", Type: "application/atom+xml", Href: data.PreviousURL})
+	}
+	if data.NextURL != "" {
+		links = append(links, jsonLink{Rel: "next", Type: "application/atom+xml", Href: data.NextURL})
+	}
+
+	feed := jsonFeed{
+		jsonNamespaces: newJSONNamespaces(true),
+		ID:             jsonText{Value: data.ID},
+		Updated:        jsonText{Value: data.Updated},
+		Category:       []jsonCategory{{Scheme: "http://schemas.google.com/g/2005#kind", Term: data.KindTerm}},
+		Title:          jsonText{Value: data.Title, Type: "text"},
+		Logo:           jsonText{Value: "http://www.gstatic.com/youtube/img/logo.png"},
+		Link:           links,
+		Author:         []jsonAuthor{{Name: jsonText{Value: "WiiTube"}, URI: jsonText{Value: data.BaseURL + "/"}}},
+		TotalResults:   jsonText{Value: data.TotalResults},
+		StartIndex:     jsonText{Value: data.StartIndex},
+		ItemsPerPage:   jsonText{Value: data.ItemsPerPage},
+		Entry:          make([]jsonEntry, len(data.Results)),
+	}
+
+	for i, result := range data.Results {
+		feed.Entry[i] = result.toJSONEntry(data.BaseURL, data.Formats)
+	}
+
+	document := map[string]interface{}{
+		"version":  "1.0",
+		"encoding": "UTF-8",
+		"feed":     feed,
+	}
+	return marshalJSON(document)
+}
+
+// GenerateFeedJSONC generates a feed in the json-c format (alt=jsonc)
+func GenerateFeedJSONC(results []SearchResult, thumbnailFormat string, options FeedOptions) (string, error) {
+	data := newFeedTemplateData(results, thumbnailFormat, options)
+
+	feed := jsoncFeed{
+		Updated:      data.Updated,
+		TotalItems:   data.TotalResults,
+		StartIndex:   data.StartIndex,
+		ItemsPerPage: data.ItemsPerPage,
+		Items:        make([]jsoncItem, len(data.Results)),
+	}
+
+	for i, result := range data.Results {
+		feed.Items[i] = result.toJSONCItem(data.BaseURL, data.Formats)
+	}
+
+	return marshalJSON(map[string]interface{}{
+		"apiVersion": "2.1",
+		"data":       feed,
+	})
+}
+
+// ToJSONEntry generates a single video entry in the legacy json format (alt=json)
+func (v *VideoInfo) ToJSONEntry(thumbnailFormat string, baseURL string, formats []MediaFormat) (string, error) {
+	data := v.ToTemplateData(thumbnailFormat, baseURL)
+
+	entry := data.toSearchResultTemplateData().toJSONEntry(data.BaseURL, formats)
+	entry.Updated = jsonText{Value: data.Updated}
+	entry.MediaGroup.Keywords = &jsonText{Value: data.Keywords}
+	entry.Statistics.LikeCount = strconv.FormatInt(data.LikeCount, 10)
+	entry.Rating = &jsonRating{
+		Average:   data.AverageRating,
+		Max:       MaxRating,
+		Min:       MinRating,
+		NumRaters: data.LikeCount + data.DislikeCount,
+		Rel:       "http://schemas.google.com/g/2005#overall",
+	}
+	entry.YouTubeRating = &jsonYouTubeRating{
+		NumDislikes: strconv.FormatInt(data.DislikeCount, 10),
+		NumLikes:    strconv.FormatInt(data.LikeCount, 10),
+	}
+	entry.jsonNamespaces = newJSONNamespaces(false)
+
+	return marshalJSON(map[string]interface{}{
+		"version":  "1.0",
+		"encoding": "UTF-8",
+		"entry":    entry,
+	})
+}
+
+// ToJSONCEntry generates a single video entry in the json-c format (alt=jsonc)
+func (v *VideoInfo) ToJSONCEntry(thumbnailFormat string, baseURL string, formats []MediaFormat) (string, error) {
+	data := v.ToTemplateData(thumbnailFormat, baseURL)
+
+	item := data.toSearchResultTemplateData().toJSONCItem(data.BaseURL, formats)
+	item.Updated = data.Updated
+	item.Tags = v.Keywords
+	item.Rating = data.AverageRating
+	item.LikeCount = strconv.FormatInt(data.LikeCount, 10)
+	item.RatingCount = data.LikeCount + data.DislikeCount
+
+	return marshalJSON(map[string]interface{}{
+		"apiVersion": "2.1",
+		"data":       item,
+	})
+}
+
+// toSearchResultTemplateData converts entry data to the data used for feed entries
+func (d VideoEntryTemplateData) toSearchResultTemplateData() SearchResultTemplateData {
+	return SearchResultTemplateData{
+		Kind:          KindVideo,
+		VideoID:       d.VideoID,
+		Title:         d.Title,
+		Author:        d.Author,
+		AuthorID:      d.AuthorID,
+		Description:   d.Description,
+		PublishedText: d.PublishedText,
+		Published:     d.Published,
+		LengthSeconds: d.LengthSeconds,
+		ViewCount:     d.ViewCount,
+		ThumbnailURL:  d.ThumbnailURL,
+	}
+}
+
+// toJSONEntry converts a feed entry to the legacy json format
+func (d SearchResultTemplateData) toJSONEntry(baseURL string, formats []MediaFormat) jsonEntry {
+	// Links to channels point to their uploads, like in the atom entries
+	author := []jsonAuthor{{
+		Name:   jsonText{Value: d.Author},
+		URI:    jsonText{Value: baseURL + "/feeds/api/users/" + d.AuthorID + "/uploads"},
+		UserID: &jsonText{Value: d.AuthorID},
+	}}
+	kindCategory := jsonCategory{Scheme: "http://schemas.google.com/g/2005#kind", Term: kindTerm(d.Kind)}
+
+	switch d.Kind {
+	case KindChannel:
+		return jsonEntry{
+			ID:       jsonText{Value: "http://gdata.youtube.com/feeds/api/channels/" + d.AuthorID},
+			Updated:  jsonText{Value: d.Published},
+			Category: []jsonCategory{kindCategory},
+			Title:    jsonText{Value: d.Title},
+			Summary:  &jsonText{Value: d.Description},
+			Link: []jsonLink{
+				{Rel: "alternate", Type: "text/html", Href: "http://www.youtube.com/channel/" + d.AuthorID},
+				{Rel: "self", Type: "application/atom+xml", Href: baseURL + "/feeds/api/users/" + d.AuthorID + "/uploads"},
+			},
+			Author: author,
+			FeedLink: []jsonFeedLink{{
+				Rel:       "http://gdata.youtube.com/schemas/2007#channel.content",
+				Href:      baseURL + "/feeds/api/users/" + d.AuthorID + "/uploads",
+				CountHint: d.VideoCount,
+			}},
+			MediaGroup: &jsonMediaGroup{Thumbnail: []jsonThumbnail{{URL: d.ThumbnailURL}}},
+			ChannelStatistics: &jsonChannelStatistics{
+				SubscriberCount: strconv.FormatInt(d.SubscriberCount, 10),
+				VideoCount:      strconv.FormatInt(d.VideoCount, 10),
+			},
+			ChannelID: &jsonText{Value: d.AuthorID},
+		}
+
+	case KindPlaylist:
+		return jsonEntry{
+			ID:       jsonText{Value: "http://gdata.youtube.com/feeds/api/playlists/" + d.PlaylistID},
+			Updated:  jsonText{Value: d.Published},
+			Category: []jsonCategory{kindCategory},
+			Title:    jsonText{Value: d.Title},
+			Summary:  &jsonText{Value: d.Description},
+			Link: []jsonLink{
+				{Rel: "alternate", Type: "text/html", Href: "http://www.youtube.com/playlist?list=" + d.PlaylistID},
+				{Rel: "self", Type: "application/atom+xml", Href: baseURL + "/feeds/api/playlists/" + d.PlaylistID},
+			},
+			Author: author,
+			FeedLink: []jsonFeedLink{{
+				Rel:       "http://gdata.youtube.com/schemas/2007#playlist",
+				Href:      baseURL + "/feeds/api/playlists/" + d.PlaylistID,
+				CountHint: d.VideoCount,
+			}},
+			MediaGroup: &jsonMediaGroup{Thumbnail: []jsonThumbnail{{URL: d.ThumbnailURL, Width: 480, Height: 360, Name: "hqdefault"}}},
+			PlaylistID: &jsonText{Value: d.PlaylistID},
+		}
+	}
+
+	categories := []jsonCategory{kindCategory}
+	mediaGroup := &jsonMediaGroup{
+		Description: &jsonText{Value: d.Description, Type: "plain"},
+		Thumbnail:   []jsonThumbnail{{URL: d.ThumbnailURL, Width: 480, Height: 360, Name: "hqdefault"}},
+		Player:      &jsonPlayer{URL: d.playerURL(baseURL, formats)},
+		Title:       &jsonText{Value: d.Title, Type: "plain"},
+		Duration:    &jsonDuration{Seconds: strconv.Itoa(d.LengthSeconds)},
+		Uploaded:    &jsonText{Value: d.Published},
+		UploaderID:  &jsonText{Value: d.AuthorID},
+		VideoID:     &jsonText{Value: d.VideoID},
+	}
+
+	for _, content := range d.mediaContent(baseURL, formats) {
+		jsonContent := jsonContent{
+			URL:        content.URL,
+			Type:       content.Type,
+			Medium:     content.Medium,
+			Expression: content.Expression,
+			Duration:   content.Duration,
+			Format:     content.Format,
+		}
+		if content.IsDefault {
+			jsonContent.IsDefault = "true"
+		}
+		mediaGroup.Content = append(mediaGroup.Content, jsonContent)
+	}
+
+	if d.Category != "" {
+		category := jsonCategory{
+			Scheme: "http://gdata.youtube.com/schemas/2007/categories.cat",
+			Term:   d.Category,
+			Label:  d.Category,
+		}
+		categories = append(categories, category)
+		mediaGroup.Category = []jsonCategory{category}
+	}
+
+	return jsonEntry{
+		ID:        jsonText{Value: "http://gdata.youtube.com/feeds/api/videos/" + d.VideoID},
+		Published: &jsonText{Value: d.Published},
+		Updated:   jsonText{Value: d.Published},
+		Category:  categories,
+		Title:     jsonText{Value: d.Title},
+		Content:   &jsonText{Value: d.Description, Type: "text"},
+		Link: []jsonLink{
+			{Rel: "alternate", Type: "text/html", Href: "http://www.youtube.com/watch?v=" + d.VideoID},
+			{Rel: "self", Type: "application/atom+xml", Href: baseURL + "/feeds/api/videos/" + d.VideoID},
+		},
+		Author:     author,
+		MediaGroup: mediaGroup,
+		Statistics: &jsonStatistics{
+			FavoriteCount: "0",
+			ViewCount:     strconv.FormatInt(d.ViewCount, 10),
+		},
+	}
+}
+
+// toJSONCItem converts a feed entry to the json-c format
+func (d SearchResultTemplateData) toJSONCItem(baseURL string, formats []MediaFormat) jsoncItem {
+	thumbnails := map[string]string{"hqDefault": d.ThumbnailURL}
+	if strings.Contains(d.ThumbnailURL, "/hqdefault.jpg") {
+		thumbnails["sqDefault"] = strings.Replace(d.ThumbnailURL, "/hqdefault.jpg", "/default.jpg", 1)
+	}
+
+	switch d.Kind {
+	case KindChannel:
+		return jsoncItem{
+			ID:              d.AuthorID,
+			Author:          d.Author,
+			Title:           d.Title,
+			Description:     d.Description,
+			Thumbnail:       thumbnails,
+			Size:            d.VideoCount,
+			SubscriberCount: &d.SubscriberCount,
+		}
+
+	case KindPlaylist:
+		return jsoncItem{
+			ID:          d.PlaylistID,
+			Author:      d.Author,
+			Title:       d.Title,
+			Description: d.Description,
+			Thumbnail:   thumbnails,
+			Size:        d.VideoCount,
+		}
+	}
+
+	content := make(map[string]string)
+	for _, format := range formats {
+		key := strconv.Itoa(format.Itag)
+		if _, ok := content[key]; !ok {
+			content[key] = format.URL(baseURL, d.VideoID)
+		}
+	}
+
+	favoriteCount := 0
+	return jsoncItem{
+		ID:            d.VideoID,
+		Uploaded:      d.Published,
+		Updated:       d.Published,
+		Uploader:      d.AuthorID,
+		Category:      d.Category,
+		Title:         d.Title,
+		Description:   d.Description,
+		Thumbnail:     thumbnails,
+		Player:        map[string]string{"default": "http://www.youtube.com/watch?v=" + d.VideoID},
+		Content:       content,
+		Duration:      d.LengthSeconds,
+		ViewCount:     &d.ViewCount,
+		FavoriteCount: &favoriteCount,
+	}
+}
+
+// marshalJSON marshals a document without escaping html characters
+func marshalJSON(document interface{}) (string, error) {
+	var buf strings.Builder
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+
+	if err := encoder.Encode(document); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
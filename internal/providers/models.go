@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/Lekuruu/give-wii-youtube/internal/templates"
 )
@@ -18,11 +19,13 @@ type VideoInfo struct {
 	LengthSeconds int         `json:"lengthSeconds"`
 	ViewCount     int64       `json:"viewCount"`
 	LikeCount     int64       `json:"likeCount"`
+	DislikeCount  int64       `json:"dislikeCount"`
 	Description   string      `json:"description"`
 	PublishedText string      `json:"publishedText"`
 	Keywords      []string    `json:"keywords"`
 	IsLive        bool        `json:"isLive"`
 	Thumbnails    []Thumbnail `json:"thumbnails"`
+	Category      string      `json:"category"`
 }
 
 // Thumbnail represents a video thumbnail
@@ -32,7 +35,14 @@ type Thumbnail struct {
 	Height int    `json:"height"`
 }
 
-// SearchResult represents a video in search results
+// Kinds of results returned by searches
+const (
+	KindVideo    = "video"
+	KindChannel  = "channel"
+	KindPlaylist = "playlist"
+)
+
+// SearchResult represents a video, channel or playlist in search results
 type SearchResult struct {
 	Type           string      `json:"type"`
 	Title          string      `json:"title"`
@@ -49,6 +59,11 @@ type SearchResult struct {
 	LengthText     string      `json:"lengthText"`
 	IsLive         bool        `json:"liveNow"`
 	Thumbnails     []Thumbnail `json:"videoThumbnails"`
+
+	// Channel & playlist results
+	PlaylistID      string `json:"playlistId"`
+	VideoCount      int64  `json:"videoCount"`
+	SubscriberCount int64  `json:"subCount"`
 }
 
 // SearchSuggestion represents a search autocomplete suggestion
@@ -64,43 +79,65 @@ type Category struct {
 	Videos []SearchResult
 }
 
-// FeedTemplateData is the data structure for feed.xml template
+// FeedTemplateData is the data structure used to generate feed documents
 type FeedTemplateData struct {
-	Results []SearchResultTemplateData
+	BaseURL      string
+	ID           string
+	Title        string
+	Updated      string
+	KindTerm     string
+	FeedURL      string
+	SelfURL      string
+	NextURL      string
+	PreviousURL  string
+	StartIndex   int
+	ItemsPerPage int
+	TotalResults int
+	Formats      []MediaFormat
+	Results      []SearchResultTemplateData
 }
 
-// SearchResultTemplateData is the data structure for search result entries in templates
+// SearchResultTemplateData is the data structure used to generate feed entries
 type SearchResultTemplateData struct {
+	Kind          string
 	VideoID       string
 	Title         string
 	Author        string
 	AuthorID      string
 	Description   string
 	PublishedText string
+	Published     string
+	Category      string
 	LengthSeconds int
 	ViewCount     int64
 	ThumbnailURL  string
+
+	// Channel & playlist entries
+	PlaylistID      string
+	VideoCount      int64
+	SubscriberCount int64
 }
 
-// VideoEntryTemplateData is the data structure for video_entry.xml template
+// VideoEntryTemplateData is the data structure used to generate single video entries
 type VideoEntryTemplateData struct {
+	BaseURL       string
 	VideoID       string
 	Title         string
 	Author        string
 	AuthorID      string
+	Description   string
+	Keywords      string
 	PublishedText string
+	Published     string
+	Updated       string
 	LengthSeconds int
 	ViewCount     int64
 	LikeCount     int64
+	DislikeCount  int64
+	AverageRating float64
 	ThumbnailURL  string
 }
 
-// SuggestionsTemplateData is the data structure for suggestions.xml template
-type SuggestionsTemplateData struct {
-	Query       string
-	Suggestions []string
-}
-
 // ToTemplateData converts a SearchResult to template data
 func (s *SearchResult) ToTemplateData(thumbnailFormat string) SearchResultTemplateData {
 	thumbnailURL := fmt.Sprintf(thumbnailFormat, s.VideoID)
@@ -108,120 +145,187 @@ func (s *SearchResult) ToTemplateData(thumbnailFormat string) SearchResultTempla
 		thumbnailURL = s.Thumbnails[0].URL
 	}
 
+	kind := s.Type
+	if kind == "" {
+		kind = KindVideo
+	}
+
 	return SearchResultTemplateData{
-		VideoID:       s.VideoID,
-		Title:         s.Title,
-		Author:        s.Author,
-		AuthorID:      s.AuthorID,
-		Description:   s.Description,
-		PublishedText: s.PublishedText,
-		LengthSeconds: s.LengthSeconds,
-		ViewCount:     s.ViewCount,
-		ThumbnailURL:  strings.Replace(thumbnailURL, "https://", "http://", 1),
+		Kind:            kind,
+		VideoID:         s.VideoID,
+		Title:           s.Title,
+		Author:          s.Author,
+		AuthorID:        s.AuthorID,
+		Description:     s.Description,
+		PublishedText:   s.PublishedText,
+		Published:       formatTimestamp(ParsePublishedText(s.PublishedText, time.Now())),
+		LengthSeconds:   s.LengthSeconds,
+		ViewCount:       s.ViewCount,
+		ThumbnailURL:    strings.Replace(thumbnailURL, "https://", "http://", 1),
+		PlaylistID:      s.PlaylistID,
+		VideoCount:      s.VideoCount,
+		SubscriberCount: s.SubscriberCount,
 	}
 }
 
 // ToTemplateData converts VideoInfo to template data
-func (v *VideoInfo) ToTemplateData(thumbnailFormat string) VideoEntryTemplateData {
+func (v *VideoInfo) ToTemplateData(thumbnailFormat string, baseURL string) VideoEntryTemplateData {
 	thumbnailURL := fmt.Sprintf(thumbnailFormat, v.VideoID)
 	if len(v.Thumbnails) > 0 {
 		thumbnailURL = v.Thumbnails[0].URL
 	}
+	now := time.Now()
 
 	return VideoEntryTemplateData{
+		BaseURL:       strings.TrimSuffix(baseURL, "/"),
 		VideoID:       v.VideoID,
 		Title:         v.Title,
 		Author:        v.Author,
 		AuthorID:      v.AuthorID,
+		Description:   v.Description,
+		Keywords:      strings.Join(v.Keywords, ", "),
 		PublishedText: v.PublishedText,
+		Published:     formatTimestamp(ParsePublishedText(v.PublishedText, now)),
+		Updated:       formatTimestamp(now),
 		LengthSeconds: v.LengthSeconds,
 		ViewCount:     v.ViewCount,
 		LikeCount:     v.LikeCount,
+		DislikeCount:  v.DislikeCount,
+		AverageRating: v.AverageRating(),
 		ThumbnailURL:  strings.Replace(thumbnailURL, "https://", "http://", 1),
 	}
 }
 
-// ToVideoInfoResponse generates the legacy get_video_info format response
-func (v *VideoInfo) ToVideoInfoResponse(thumbnailFormat string) string {
-	thumbnailURL := fmt.Sprintf(thumbnailFormat, v.VideoID)
-	fmtList := "43/854x480/9/0/115"
-	fmtStreamMap := "43|"
-	fmtMap := "43/0/7/0/0"
-
-	params := []string{
-		fmt.Sprintf("status=%s", "ok"),
-		fmt.Sprintf("length_seconds=%d", v.LengthSeconds),
-		fmt.Sprintf("keywords=%s", "a"),
-		fmt.Sprintf("vq=%s", "None"),
-		fmt.Sprintf("muted=%d", 0),
-		fmt.Sprintf("avg_rating=%.1f", 5.0),
-		fmt.Sprintf("thumbnailUrl=%s", thumbnailURL),
-		fmt.Sprintf("allow_ratings=%s", "1"),
-		fmt.Sprintf("hl=%s", "en"),
-		fmt.Sprintf("ftoken=%s", ""),
-		fmt.Sprintf("allow_embed=%s", "1"),
-		fmt.Sprintf("fmtMap=%s", fmtMap),
-		fmt.Sprintf("fmt_url_map=%s", fmtStreamMap),
-		fmt.Sprintf("token=%s", "null"),
-		fmt.Sprintf("plid=%s", "null"),
-		fmt.Sprintf("track_embed=%d", 0),
-		fmt.Sprintf("author=%s", v.Author),
-		fmt.Sprintf("title=%s", v.Title),
-		fmt.Sprintf("videoId=%s", v.VideoID),
-		fmt.Sprintf("fmtList=%s", fmtList),
-		fmt.Sprintf("fmtStreamMap=%s", fmtStreamMap),
+// ToXMLEntry generates an Atom entry for the video
+func (v *VideoInfo) ToXMLEntry(thumbnailFormat string, baseURL string, formats []MediaFormat) (string, error) {
+	data := v.ToTemplateData(thumbnailFormat, baseURL)
+
+	entry := data.toSearchResultTemplateData().toXMLEntry(data.BaseURL, formats)
+	entry.Namespaces = templates.NewNamespaces(false)
+	entry.Updated = data.Updated
+	entry.MediaGroup.Keywords = &data.Keywords
+	entry.Statistics.LikeCount = &data.LikeCount
+	entry.Rating = &templates.Rating{
+		Average:   data.AverageRating,
+		Max:       MaxRating,
+		Min:       MinRating,
+		NumRaters: data.LikeCount + data.DislikeCount,
+		Rel:       "http://schemas.google.com/g/2005#overall",
+	}
+	entry.YouTubeRating = &templates.YouTubeRating{
+		NumLikes:    data.LikeCount,
+		NumDislikes: data.DislikeCount,
 	}
 
-	return strings.Join(params, "&")
+	return templates.Render(entry)
 }
 
-// ToXMLEntry generates an Atom entry for the video using templates
-func (v *VideoInfo) ToXMLEntry(thumbnailFormat string) (string, error) {
-	templates := templates.GetInstance()
-	if templates == nil {
-		return "", fmt.Errorf("templates not initialized")
+// GenerateFeedXML generates a complete Atom feed from search results
+func GenerateFeedXML(results []SearchResult, thumbnailFormat string, options FeedOptions) (string, error) {
+	data := newFeedTemplateData(results, thumbnailFormat, options)
+
+	links := []templates.Link{
+		{Rel: "alternate", Type: "text/html", Href: "http://www.youtube.com"},
+		{Rel: "http://schemas.google.com/g/2005#feed", Type: "application/atom+xml", Href: data.FeedURL},
+		{Rel: "self", Type: "application/atom+xml", Href: data.SelfURL},
+	}
+	if data.PreviousURL != "" {
+		links = append(links, templates.Link{Rel: "previous", Type: "application/atom+xml", Href: data.PreviousURL})
+	}
+	if data.NextURL != "" {
+		links = append(links, templates.Link{Rel: "next", Type: "application/atom+xml", Href: data.NextURL})
 	}
-	return templates.RenderVideoEntry(v.ToTemplateData(thumbnailFormat))
-}
 
-// GenerateFeedXML generates a complete Atom feed from search results using templates
-func GenerateFeedXML(results []SearchResult, thumbnailFormat string) (string, error) {
-	templates := templates.GetInstance()
-	if templates == nil {
-		return "", fmt.Errorf("templates not initialized")
+	feed := templates.Feed{
+		Namespaces:   templates.NewNamespaces(true),
+		ID:           data.ID,
+		Updated:      data.Updated,
+		Category:     []templates.Category{{Scheme: "http://schemas.google.com/g/2005#kind", Term: data.KindTerm}},
+		Title:        templates.TypedText{Type: "text", Value: data.Title},
+		Logo:         "http://www.gstatic.com/youtube/img/logo.png",
+		Links:        links,
+		Author:       []templates.Author{{Name: "WiiTube", URI: data.BaseURL + "/"}},
+		Generator:    &templates.Generator{Version: "2.1", URI: data.BaseURL + "/", Name: "WiiTube"},
+		TotalResults: data.TotalResults,
+		StartIndex:   data.StartIndex,
+		ItemsPerPage: data.ItemsPerPage,
+		Entries:      make([]templates.Entry, len(data.Results)),
 	}
 
-	// Convert results to template data
+	for i, result := range data.Results {
+		feed.Entries[i] = result.toXMLEntry(data.BaseURL, data.Formats)
+	}
+
+	return templates.Render(feed)
+}
+
+// newFeedTemplateData converts search results and feed options to template data
+func newFeedTemplateData(results []SearchResult, thumbnailFormat string, options FeedOptions) FeedTemplateData {
 	templateResults := make([]SearchResultTemplateData, len(results))
 	for i, r := range results {
 		templateResults[i] = r.ToTemplateData(thumbnailFormat)
+		templateResults[i].Category = options.Category
+	}
+
+	if options.ID == "" {
+		options.ID = "tag:youtube.com,2008:videos"
+	}
+	if options.ItemsPerPage <= 0 {
+		options.ItemsPerPage = DefaultItemsPerPage
+	}
+	if options.StartIndex <= 0 {
+		options.StartIndex = 1
 	}
 
-	data := FeedTemplateData{Results: templateResults}
-	return templates.RenderFeed(data)
+	return FeedTemplateData{
+		BaseURL:      strings.TrimSuffix(options.BaseURL, "/"),
+		ID:           options.ID,
+		Title:        options.Title,
+		Updated:      formatTimestamp(time.Now()),
+		KindTerm:     kindTerm(options.Kind),
+		FeedURL:      options.FeedURL(),
+		SelfURL:      options.SelfURL(),
+		NextURL:      options.NextURL(),
+		PreviousURL:  options.PreviousURL(),
+		StartIndex:   options.StartIndex,
+		ItemsPerPage: options.ItemsPerPage,
+		TotalResults: options.TotalResults,
+		Formats:      options.Formats,
+		Results:      templateResults,
+	}
 }
 
-// GenerateSuggestionsXML generates XML for search suggestions using templates
+// GenerateSuggestionsXML generates XML for search suggestions
 func GenerateSuggestionsXML(query string, suggestions []string) (string, error) {
-	templates := templates.GetInstance()
-	if templates == nil {
-		return "", fmt.Errorf("templates not initialized")
+	document := templates.Suggestions{
+		CompleteSuggestion: templates.CompleteSuggestion{
+			Query:       query,
+			Suggestions: make([]templates.Suggestion, len(suggestions)),
+		},
 	}
 
-	data := SuggestionsTemplateData{
-		Query:       query,
-		Suggestions: suggestions,
+	for i, suggestion := range suggestions {
+		document.CompleteSuggestion.Suggestions[i] = templates.Suggestion{Data: suggestion}
 	}
-	return templates.RenderSuggestions(data)
+
+	return templates.Render(document)
 }
 
-// GenerateErrorXML generates an XML error response using templates
-func GenerateErrorXML(message string) (string, error) {
-	templates := templates.GetInstance()
-	if templates == nil {
-		return "", fmt.Errorf("templates not initialized")
+// GenerateErrorXML generates a gdata error document with a single error
+func GenerateErrorXML(domain string, code string, location string, reason string) (string, error) {
+	entry := templates.Error{
+		Domain:         domain,
+		Code:           code,
+		InternalReason: reason,
 	}
-	return templates.RenderError(message)
+	if location != "" {
+		entry.Location = &templates.ErrorLocation{Type: "parameter", Value: location}
+	}
+
+	return templates.Render(templates.Errors{
+		Namespace: templates.NamespaceGData,
+		Errors:    []templates.Error{entry},
+	})
 }
 
 // ParseViewCount parses view count text like "1.5M views" to an integer
@@ -258,6 +362,15 @@ func ParseViewCount(viewCountText string) int64 {
 	return result
 }
 
+// parseCountText parses count text like "1.2K subscribers" or "42 videos" to an integer
+func parseCountText(countText string) int64 {
+	fields := strings.Fields(countText)
+	if len(fields) == 0 {
+		return 0
+	}
+	return ParseViewCount(fields[0])
+}
+
 // ParseDuration parses duration text like "3:45" or "1:23:45" to seconds
 func ParseDuration(durationText string) int {
 	parts := strings.Split(durationText, ":")
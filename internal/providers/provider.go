@@ -3,8 +3,12 @@ package providers
 // Provider interface for video providers, e.g. youtube
 type Provider interface {
 	GetVideoInfo(videoId string, country string, language string) (*VideoInfo, error)
+	PopulateRatings(info *VideoInfo, country string, language string)
 	Search(query string, maxResults int, country string, language string) ([]SearchResult, error)
+	SearchKinds(query string, kinds []string, maxResults int, country string, language string) ([]SearchResult, error)
 	GetTrending(category string, maxResults int, country string, language string) ([]SearchResult, error)
+	GetChannelVideos(channelId string, maxResults int, country string, language string) ([]SearchResult, error)
+	GetPlaylistVideos(playlistId string, maxResults int, country string, language string) ([]SearchResult, error)
 	GetSearchSuggestions(query string, country string, language string) (*SearchSuggestion, error)
 	GetVideoUrlFormat() string
 	GetThumbnailUrlFormat() string
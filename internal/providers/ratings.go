@@ -0,0 +1,154 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scale of the ratings reported by the original api
+const (
+	MinRating = 1
+	MaxRating = 5
+)
+
+// Maximum time that fetching the ratings of a video may take,
+// since they are not worth delaying the response for long
+const ratingsTimeout = 5 * time.Second
+
+// dislikeResponse is the response of a Return YouTube Dislike compatible api
+type dislikeResponse struct {
+	Likes    int64 `json:"likes"`
+	Dislikes int64 `json:"dislikes"`
+}
+
+// fetchLikeCount retrieves the like count of a video from the Innertube "next" endpoint
+func (p *YouTubeProvider) fetchLikeCount(ctx context.Context, videoID string, country string, language string) (int64, error) {
+	payload := map[string]interface{}{
+		"context": getClientContext(country, language),
+		"videoId": videoID,
+	}
+
+	data, err := p.performInnertubeRequestContext(ctx, InnertubeNextUrl, payload)
+	if err != nil {
+		return 0, err
+	}
+
+	likeCount, ok := parseLikeCount(data)
+	if !ok {
+		return 0, fmt.Errorf("like count not found in response")
+	}
+	return likeCount, nil
+}
+
+// parseLikeCount extracts the like count from a "next" response
+func parseLikeCount(data map[string]interface{}) (int64, bool) {
+	// Newer responses contain the exact count inside the framework updates
+	if value, ok := findValue(data, "likeCountIfIndifferentNumber"); ok {
+		if count, ok := value.(string); ok {
+			var result int64
+			if _, err := fmt.Sscanf(count, "%d", &result); err == nil {
+				return result, true
+			}
+		}
+	}
+
+	// Otherwise fall back to the abbreviated text of the like button, e.g. "1.2K"
+	value, ok := findValue(data, "likeButtonViewModel")
+	if !ok {
+		return 0, false
+	}
+	likeButton, ok := value.(map[string]interface{})
+	if !ok {
+		return 0, false
+	}
+	text := getNestedString(likeButton,
+		"likeButtonViewModel", "toggleButtonViewModel", "toggleButtonViewModel",
+		"defaultButtonViewModel", "buttonViewModel", "title",
+	)
+	if text == "" {
+		return 0, false
+	}
+	return ParseViewCount(text), true
+}
+
+// fetchDislikes retrieves the estimated votes of a video from the configured dislike api
+func (p *YouTubeProvider) fetchDislikes(ctx context.Context, videoID string) (*dislikeResponse, error) {
+	endpoint := fmt.Sprintf("%s/votes?videoId=%s", strings.TrimSuffix(p.DislikeApiUrl, "/"), url.QueryEscape(videoID))
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := p.Http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("API returned status %d", resp.StatusCode)
+	}
+
+	var votes dislikeResponse
+	if err := json.NewDecoder(resp.Body).Decode(&votes); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return &votes, nil
+}
+
+// PopulateRatings fills in the like and dislike counts of a video, leaving
+// them empty if they can't be retrieved within a short amount of time
+func (p *YouTubeProvider) PopulateRatings(info *VideoInfo, country string, language string) {
+	ctx, cancel := context.WithTimeout(context.Background(), ratingsTimeout)
+	defer cancel()
+
+	var likeCount int64
+	var votes *dislikeResponse
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		likeCount, _ = p.fetchLikeCount(ctx, info.VideoID, country, language)
+	}()
+
+	if p.DislikeApiUrl != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			votes, _ = p.fetchDislikes(ctx, info.VideoID)
+		}()
+	}
+	wg.Wait()
+
+	info.LikeCount = likeCount
+	if votes == nil {
+		return
+	}
+	if info.LikeCount == 0 {
+		info.LikeCount = votes.Likes
+	}
+	info.DislikeCount = votes.Dislikes
+}
+
+// RatingCount returns the amount of ratings of a video
+func (v *VideoInfo) RatingCount() int64 {
+	return v.LikeCount + v.DislikeCount
+}
+
+// AverageRating maps the like ratio of a video to the 1-5 scale of the original api
+func (v *VideoInfo) AverageRating() float64 {
+	if v.RatingCount() == 0 {
+		return MaxRating
+	}
+	ratio := float64(v.LikeCount) / float64(v.RatingCount())
+	rating := MinRating + ratio*(MaxRating-MinRating)
+	return math.Round(rating*100) / 100
+}
@@ -0,0 +1,81 @@
+package providers
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Thumbnail sizes listed in get_video_info responses
+var videoInfoThumbnails = map[string]string{
+	"thumbnail_url": "default",
+	"iurlmq":        "mqdefault",
+	"iurl":          "hqdefault",
+	"iurlsd":        "sddefault",
+	"iurlmaxres":    "maxresdefault",
+}
+
+// ToVideoInfoResponse generates the legacy get_video_info format response
+func (v *VideoInfo) ToVideoInfoResponse(thumbnailFormat string, baseURL string, language string, formats []MediaFormat) string {
+	formats = uniqueFormats(formats)
+	if language == "" {
+		language = "en"
+	}
+
+	var fmtList, fmtMap, fmtUrlMap, fmtStreamMap, streamMap []string
+	for _, format := range formats {
+		streamURL := format.URL(baseURL, v.VideoID)
+		itag := strconv.Itoa(format.Itag)
+
+		fmtList = append(fmtList, fmt.Sprintf("%s/%s/9/0/115", itag, format.Resolution()))
+		fmtMap = append(fmtMap, fmt.Sprintf("%s/0/7/0/0", itag))
+		fmtUrlMap = append(fmtUrlMap, itag+"|"+streamURL)
+		fmtStreamMap = append(fmtStreamMap, itag+"|"+streamURL+"|")
+
+		stream := url.Values{}
+		stream.Set("itag", itag)
+		stream.Set("url", streamURL)
+		stream.Set("type", format.MimeType)
+		stream.Set("quality", format.QualityLabel())
+		streamMap = append(streamMap, stream.Encode())
+	}
+
+	params := url.Values{}
+	params.Set("status", "ok")
+	params.Set("video_id", v.VideoID)
+	params.Set("videoId", v.VideoID)
+	params.Set("title", v.Title)
+	params.Set("author", v.Author)
+	params.Set("length_seconds", strconv.Itoa(v.LengthSeconds))
+	params.Set("view_count", strconv.FormatInt(v.ViewCount, 10))
+	params.Set("keywords", strings.Join(v.Keywords, ","))
+	params.Set("hl", language)
+	params.Set("vq", "None")
+	params.Set("muted", "0")
+	params.Set("avg_rating", fmt.Sprintf("%.2f", v.AverageRating()))
+	params.Set("allow_ratings", "1")
+	params.Set("allow_embed", "1")
+	params.Set("ftoken", "")
+	params.Set("token", "null")
+	params.Set("plid", "null")
+	params.Set("track_embed", "0")
+
+	thumbnailURL := fmt.Sprintf(thumbnailFormat, v.VideoID)
+	params.Set("thumbnailUrl", thumbnailURL)
+	for key, name := range videoInfoThumbnails {
+		params.Set(key, strings.Replace(thumbnailURL, "hqdefault", name, 1))
+	}
+
+	// Stream maps in all formats used by the different player versions
+	params.Set("fmt_list", strings.Join(fmtList, ","))
+	params.Set("fmtList", strings.Join(fmtList, ","))
+	params.Set("fmtMap", strings.Join(fmtMap, ","))
+	params.Set("fmt_map", strings.Join(fmtMap, ","))
+	params.Set("fmt_url_map", strings.Join(fmtUrlMap, ","))
+	params.Set("fmtStreamMap", strings.Join(fmtStreamMap, ","))
+	params.Set("fmt_stream_map", strings.Join(fmtStreamMap, ","))
+	params.Set("url_encoded_fmt_stream_map", strings.Join(streamMap, ","))
+
+	return params.Encode()
+}
@@ -0,0 +1,139 @@
+package providers
+
+import "github.com/Lekuruu/give-wii-youtube/internal/templates"
+
+// toXMLEntry converts a feed entry to its atom representation
+func (d SearchResultTemplateData) toXMLEntry(baseURL string, formats []MediaFormat) templates.Entry {
+	// Channels are only served as their uploads feed, so that's where links to them point
+	author := []templates.Author{{
+		Name:   d.Author,
+		URI:    baseURL + "/feeds/api/users/" + d.AuthorID + "/uploads",
+		UserID: d.AuthorID,
+	}}
+	kindCategory := templates.Category{Scheme: "http://schemas.google.com/g/2005#kind", Term: kindTerm(d.Kind)}
+
+	switch d.Kind {
+	case KindChannel:
+		return templates.Entry{
+			ID:       "http://gdata.youtube.com/feeds/api/channels/" + d.AuthorID,
+			Updated:  d.Published,
+			Category: []templates.Category{kindCategory},
+			Title:    d.Title,
+			Summary:  &d.Description,
+			Links: []templates.Link{
+				{Rel: "alternate", Type: "text/html", Href: "http://www.youtube.com/channel/" + d.AuthorID},
+				{Rel: "self", Type: "application/atom+xml", Href: baseURL + "/feeds/api/users/" + d.AuthorID + "/uploads"},
+			},
+			Author: author,
+			FeedLinks: []templates.FeedLink{{
+				Rel:       "http://gdata.youtube.com/schemas/2007#channel.content",
+				Href:      baseURL + "/feeds/api/users/" + d.AuthorID + "/uploads",
+				CountHint: d.VideoCount,
+			}},
+			MediaThumbnail: &templates.Thumbnail{URL: d.ThumbnailURL},
+			ChannelStatistics: &templates.ChannelStatistics{
+				SubscriberCount: d.SubscriberCount,
+				VideoCount:      d.VideoCount,
+			},
+			ChannelID: d.AuthorID,
+		}
+
+	case KindPlaylist:
+		return templates.Entry{
+			ID:       "http://gdata.youtube.com/feeds/api/playlists/" + d.PlaylistID,
+			Updated:  d.Published,
+			Category: []templates.Category{kindCategory},
+			Title:    d.Title,
+			Summary:  &d.Description,
+			Links: []templates.Link{
+				{Rel: "alternate", Type: "text/html", Href: "http://www.youtube.com/playlist?list=" + d.PlaylistID},
+				{Rel: "self", Type: "application/atom+xml", Href: baseURL + "/feeds/api/playlists/" + d.PlaylistID},
+			},
+			Author: author,
+			FeedLinks: []templates.FeedLink{{
+				Rel:       "http://gdata.youtube.com/schemas/2007#playlist",
+				Href:      baseURL + "/feeds/api/playlists/" + d.PlaylistID,
+				CountHint: d.VideoCount,
+			}},
+			MediaGroup: &templates.MediaGroup{
+				Thumbnail: []templates.Thumbnail{{Name: "hqdefault", URL: d.ThumbnailURL, Width: 480, Height: 360}},
+			},
+			PlaylistID: d.PlaylistID,
+		}
+	}
+
+	categories := []templates.Category{kindCategory}
+	mediaGroup := &templates.MediaGroup{
+		Content: d.mediaContent(baseURL, formats),
+		Credit: &templates.MediaCredit{
+			Role:    "uploader",
+			Scheme:  "urn:youtube",
+			Display: d.Author,
+			Value:   d.AuthorID,
+		},
+		Description: &templates.TypedText{Type: "plain", Value: d.Description},
+		Player:      &templates.MediaPlayer{URL: d.playerURL(baseURL, formats)},
+		Thumbnail:   []templates.Thumbnail{{Name: "hqdefault", URL: d.ThumbnailURL, Width: 480, Height: 360}},
+		Title:       &templates.TypedText{Type: "plain", Value: d.Title},
+		Duration:    &templates.Duration{Seconds: d.LengthSeconds},
+		Uploaded:    d.Published,
+		UploaderID:  d.AuthorID,
+		VideoID:     d.VideoID,
+	}
+
+	if d.Category != "" {
+		categories = append(categories, templates.Category{
+			Scheme: "http://gdata.youtube.com/schemas/2007/categories.cat",
+			Term:   d.Category,
+			Label:  d.Category,
+		})
+		mediaGroup.Category = []templates.MediaCategory{{
+			Label:  d.Category,
+			Scheme: "http://gdata.youtube.com/schemas/2007/categories.cat",
+			Value:  d.Category,
+		}}
+	}
+
+	return templates.Entry{
+		ID:        "http://gdata.youtube.com/feeds/api/videos/" + d.VideoID,
+		Published: d.Published,
+		Updated:   d.Published,
+		Category:  categories,
+		Title:     d.Title,
+		Content:   &templates.TypedText{Type: "text", Value: d.Description},
+		Links: []templates.Link{
+			{Rel: "alternate", Type: "text/html", Href: "http://www.youtube.com/watch?v=" + d.VideoID},
+			{Rel: "self", Type: "application/atom+xml", Href: baseURL + "/feeds/api/videos/" + d.VideoID},
+		},
+		Author:     author,
+		MediaGroup: mediaGroup,
+		Statistics: &templates.Statistics{ViewCount: d.ViewCount},
+	}
+}
+
+// mediaContent returns the media:content elements for the stream formats of a video
+func (d SearchResultTemplateData) mediaContent(baseURL string, formats []MediaFormat) []templates.MediaContent {
+	content := make([]templates.MediaContent, len(formats))
+	for i, format := range formats {
+		content[i] = templates.MediaContent{
+			URL:        format.URL(baseURL, d.VideoID),
+			Type:       format.MimeType,
+			Medium:     "video",
+			IsDefault:  format.IsDefault,
+			Expression: "full",
+			Duration:   d.LengthSeconds,
+			Format:     format.Itag,
+		}
+	}
+	return content
+}
+
+// playerURL returns the url of the default stream format, falling back to the video page
+func (d SearchResultTemplateData) playerURL(baseURL string, formats []MediaFormat) string {
+	for _, format := range formats {
+		if format.IsDefault {
+			return format.URL(baseURL, d.VideoID)
+		}
+	}
+	return "http://www.youtube.com/watch?v=" + d.VideoID
+}
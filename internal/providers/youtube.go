@@ -2,6 +2,7 @@ package providers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -16,6 +17,7 @@ import (
 type YouTubeProvider struct {
 	Http           *http.Client
 	TrendingParams map[string]string
+	DislikeApiUrl  string // Optional Return YouTube Dislike compatible api
 }
 
 // Innertube API endpoints
@@ -23,25 +25,43 @@ const (
 	InnertubePlayerUrl = "https://www.youtube.com/youtubei/v1/player"
 	InnertubeSearchUrl = "https://www.youtube.com/youtubei/v1/search"
 	InnertubeGroupUrl  = "https://www.youtube.com/youtubei/v1/browse"
+	InnertubeNextUrl   = "https://www.youtube.com/youtubei/v1/next"
 	SuggestUrl         = "https://suggestqueries-clients6.youtube.com/complete/search"
 )
 
+// Innertube search filters for a single result kind
+var searchFilterParams = map[string]string{
+	KindVideo:    "EgIQAQ%3D%3D",
+	KindChannel:  "EgIQAg%3D%3D",
+	KindPlaylist: "EgIQAw%3D%3D",
+}
+
+// Innertube browse params for the "Videos" tab of a channel
+const channelVideosParams = "EgZ2aWRlb3PyBgQKAjoA"
+
 // NewYouTubeProvider creates a new YouTube provider
-func NewYouTubeProvider(trendingParams map[string]string) *YouTubeProvider {
+func NewYouTubeProvider(trendingParams map[string]string, dislikeApiUrl string) *YouTubeProvider {
 	return &YouTubeProvider{
 		Http:           &http.Client{Timeout: 30 * time.Second},
 		TrendingParams: trendingParams,
+		DislikeApiUrl:  dislikeApiUrl,
 	}
 }
 
 // performInnertubeRequest sends a POST request to an Innertube endpoint
 func (p *YouTubeProvider) performInnertubeRequest(endpoint string, payload map[string]interface{}) (map[string]interface{}, error) {
+	return p.performInnertubeRequestContext(context.Background(), endpoint, payload)
+}
+
+// performInnertubeRequestContext sends a POST request to an Innertube endpoint,
+// which is cancelled along with the given context
+func (p *YouTubeProvider) performInnertubeRequestContext(ctx context.Context, endpoint string, payload map[string]interface{}) (map[string]interface{}, error) {
 	jsonData, err := json.Marshal(payload)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal payload: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", endpoint, bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewBuffer(jsonData))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
@@ -57,7 +77,13 @@ func (p *YouTubeProvider) performInnertubeRequest(endpoint string, payload map[s
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, fmt.Errorf("API returned status %d: %w", resp.StatusCode, ErrNotFound)
+	case http.StatusTooManyRequests:
+		return nil, fmt.Errorf("API returned status %d: %w", resp.StatusCode, ErrRateLimited)
+	default:
 		body, _ := io.ReadAll(resp.Body)
 		return nil, fmt.Errorf("API returned status %d: %s", resp.StatusCode, string(body))
 	}
@@ -84,7 +110,12 @@ func (p *YouTubeProvider) GetVideoInfo(videoID string, country string, language
 
 	videoDetails, ok := data["videoDetails"].(map[string]interface{})
 	if !ok {
-		return nil, fmt.Errorf("videoDetails not found in response")
+		// Unavailable videos only contain a playability status
+		reason := getNestedString(data, "playabilityStatus", "reason")
+		if reason == "" {
+			reason = "videoDetails not found in response"
+		}
+		return nil, fmt.Errorf("%s: %w", reason, ErrNotFound)
 	}
 
 	info := &VideoInfo{
@@ -111,19 +142,38 @@ func (p *YouTubeProvider) GetVideoInfo(videoID string, country string, language
 
 	info.Thumbnails = extractThumbnails(videoDetails)
 	info.PublishedText = getNestedString(data, "microformat", "playerMicroformatRenderer", "publishDate")
+	info.Category = getNestedString(data, "microformat", "playerMicroformatRenderer", "category")
 	return info, nil
 }
 
 // Search performs a video search
 func (p *YouTubeProvider) Search(query string, maxResults int, country string, language string) ([]SearchResult, error) {
+	return p.SearchKinds(query, []string{KindVideo}, maxResults, country, language)
+}
+
+// SearchKinds performs a search that returns results of the given kinds,
+// e.g. videos, channels and playlists
+func (p *YouTubeProvider) SearchKinds(query string, kinds []string, maxResults int, country string, language string) ([]SearchResult, error) {
 	if maxResults <= 0 {
 		maxResults = 20
 	}
 
+	allowedKinds := make(map[string]bool)
+	for _, kind := range kinds {
+		allowedKinds[kind] = true
+	}
+
+	// Searching for a single kind can be filtered upstream,
+	// otherwise we have to filter the mixed results ourselves
+	params := ""
+	if len(kinds) == 1 {
+		params = searchFilterParams[kinds[0]]
+	}
+
 	payload := map[string]interface{}{
 		"context": getClientContext(country, language),
 		"query":   query,
-		"params":  "",
+		"params":  params,
 	}
 
 	data, err := p.performInnertubeRequest(InnertubeSearchUrl, payload)
@@ -131,7 +181,7 @@ func (p *YouTubeProvider) Search(query string, maxResults int, country string, l
 		return nil, err
 	}
 
-	return p.parseSearchResults(data, maxResults)
+	return p.parseSearchResults(data, allowedKinds, maxResults)
 }
 
 // GetTrending retrieves trending videos for a category
@@ -159,6 +209,61 @@ func (p *YouTubeProvider) GetTrending(category string, maxResults int, country s
 	return p.parseTrendingResults(data, maxResults)
 }
 
+// GetChannelVideos retrieves the latest uploads of a channel
+func (p *YouTubeProvider) GetChannelVideos(channelId string, maxResults int, country string, language string) ([]SearchResult, error) {
+	if maxResults <= 0 {
+		maxResults = 20
+	}
+
+	payload := map[string]interface{}{
+		"context":  getClientContext(country, language),
+		"browseId": channelId,
+		"params":   channelVideosParams,
+	}
+
+	data, err := p.performInnertubeRequest(InnertubeGroupUrl, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := p.parseBrowseResults(data, maxResults)
+	if err != nil {
+		return nil, err
+	}
+
+	// Videos inside of a channel tab don't contain any author information
+	channelName := getNestedString(data, "metadata", "channelMetadataRenderer", "title")
+	for i := range results {
+		if results[i].Author == "" {
+			results[i].Author = channelName
+		}
+		if results[i].AuthorID == "" {
+			results[i].AuthorID = channelId
+		}
+	}
+
+	return results, nil
+}
+
+// GetPlaylistVideos retrieves the videos of a playlist
+func (p *YouTubeProvider) GetPlaylistVideos(playlistId string, maxResults int, country string, language string) ([]SearchResult, error) {
+	if maxResults <= 0 {
+		maxResults = 20
+	}
+
+	payload := map[string]interface{}{
+		"context":  getClientContext(country, language),
+		"browseId": "VL" + playlistId,
+	}
+
+	data, err := p.performInnertubeRequest(InnertubeGroupUrl, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.parseBrowseResults(data, maxResults)
+}
+
 // GetSearchSuggestions retrieves search autocomplete suggestions
 func (p *YouTubeProvider) GetSearchSuggestions(query string, country string, language string) (*SearchSuggestion, error) {
 	params := url.Values{}
@@ -181,6 +286,10 @@ func (p *YouTubeProvider) GetSearchSuggestions(query string, country string, lan
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return nil, fmt.Errorf("suggestions returned status %d: %w", resp.StatusCode, ErrRateLimited)
+	}
+
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return nil, err
@@ -230,8 +339,8 @@ func (p *YouTubeProvider) GetThumbnailUrlFormat() string {
 	return "http://i.ytimg.com/vi/%s/hqdefault.jpg"
 }
 
-// parseSearchResults parses search results from Innertube response
-func (p *YouTubeProvider) parseSearchResults(data map[string]interface{}, maxResults int) ([]SearchResult, error) {
+// parseSearchResults parses search results of the allowed kinds from Innertube response
+func (p *YouTubeProvider) parseSearchResults(data map[string]interface{}, kinds map[string]bool, maxResults int) ([]SearchResult, error) {
 	var results []SearchResult
 
 	contents, ok := data["contents"].(map[string]interface{})
@@ -281,12 +390,21 @@ func (p *YouTubeProvider) parseSearchResults(data map[string]interface{}, maxRes
 				continue
 			}
 
-			videoRenderer, ok := itemMap["videoRenderer"].(map[string]interface{})
-			if !ok {
+			var result SearchResult
+
+			if videoRenderer := getMap(itemMap, "videoRenderer"); videoRenderer != nil {
+				result = p.parseVideoRenderer(videoRenderer)
+			} else if channelRenderer := getMap(itemMap, "channelRenderer"); channelRenderer != nil {
+				result = p.parseChannelRenderer(channelRenderer)
+			} else if playlistRenderer := getMap(itemMap, "playlistRenderer"); playlistRenderer != nil {
+				result = p.parsePlaylistRenderer(playlistRenderer)
+			} else {
 				continue
 			}
 
-			result := p.parseVideoRenderer(videoRenderer)
+			if !kinds[result.Type] {
+				continue
+			}
 			results = append(results, result)
 
 			if len(results) >= maxResults {
@@ -361,6 +479,44 @@ func (p *YouTubeProvider) parseTrendingResults(data map[string]interface{}, maxR
 	return results, nil
 }
 
+// parseBrowseResults parses videos from the selected tab of a channel or playlist browse response
+func (p *YouTubeProvider) parseBrowseResults(data map[string]interface{}, maxResults int) ([]SearchResult, error) {
+	var results []SearchResult
+
+	tabs := getNestedSlice(data, "contents", "twoColumnBrowseResultsRenderer", "tabs")
+	if tabs == nil {
+		return results, fmt.Errorf("tabs not found in response")
+	}
+
+	for _, tab := range tabs {
+		tabMap, ok := tab.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		// Only the selected tab contains any content
+		content := getNestedMap(tabMap, "tabRenderer", "content")
+		if content == nil {
+			continue
+		}
+
+		items := getNestedSlice(content, "richGridRenderer", "contents")
+		if items == nil {
+			items = getNestedSlice(content, "sectionListRenderer", "contents")
+		}
+
+		for _, video := range p.extractVideosFromItems(items) {
+			results = append(results, video)
+
+			if len(results) >= maxResults {
+				return results, nil
+			}
+		}
+	}
+
+	return results, nil
+}
+
 // extractVideosFromItems recursively extracts videos from various item types
 func (p *YouTubeProvider) extractVideosFromItems(items []interface{}) []SearchResult {
 	var results []SearchResult
@@ -395,6 +551,16 @@ func (p *YouTubeProvider) extractVideosFromItems(items []interface{}) []SearchRe
 			results = append(results, p.parseVideoRenderer(videoRenderer))
 			continue
 		}
+
+		if contents := getNestedSlice(itemMap, "playlistVideoListRenderer", "contents"); contents != nil {
+			results = append(results, p.extractVideosFromItems(contents)...)
+			continue
+		}
+
+		if videoRenderer := getMap(itemMap, "playlistVideoRenderer"); videoRenderer != nil {
+			results = append(results, p.parsePlaylistVideoRenderer(videoRenderer))
+			continue
+		}
 	}
 
 	return results
@@ -403,7 +569,7 @@ func (p *YouTubeProvider) extractVideosFromItems(items []interface{}) []SearchRe
 // parseVideoRenderer parses a videoRenderer object into a SearchResult
 func (p *YouTubeProvider) parseVideoRenderer(vr map[string]interface{}) SearchResult {
 	result := SearchResult{
-		Type:    "video",
+		Type:    KindVideo,
 		VideoID: getString(vr, "videoId"),
 	}
 
@@ -434,6 +600,86 @@ func (p *YouTubeProvider) parseVideoRenderer(vr map[string]interface{}) SearchRe
 	return result
 }
 
+// parsePlaylistVideoRenderer parses a playlistVideoRenderer object into a SearchResult
+func (p *YouTubeProvider) parsePlaylistVideoRenderer(vr map[string]interface{}) SearchResult {
+	result := SearchResult{
+		Type:    KindVideo,
+		VideoID: getString(vr, "videoId"),
+	}
+
+	result.Title = getRunsText(vr, "title")
+	result.Author = getRunsText(vr, "shortBylineText")
+
+	if browseEndpoint := getNestedMap(vr, "shortBylineText", "runs", "0", "navigationEndpoint", "browseEndpoint"); browseEndpoint != nil {
+		result.AuthorID = getString(browseEndpoint, "browseId")
+		result.AuthorURL = getString(browseEndpoint, "canonicalBaseUrl")
+	}
+
+	result.Thumbnails = extractThumbnails(vr)
+	result.LengthSeconds = getInt(vr, "lengthSeconds")
+	result.LengthText = getNestedString(vr, "lengthText", "simpleText")
+	return result
+}
+
+// parseChannelRenderer parses a channelRenderer object into a SearchResult
+func (p *YouTubeProvider) parseChannelRenderer(cr map[string]interface{}) SearchResult {
+	result := SearchResult{
+		Type:     KindChannel,
+		AuthorID: getString(cr, "channelId"),
+	}
+
+	result.Title = getNestedString(cr, "title", "simpleText")
+	result.Author = result.Title
+	result.AuthorURL = getNestedString(cr, "navigationEndpoint", "browseEndpoint", "canonicalBaseUrl")
+	result.Description = getRunsText(cr, "descriptionSnippet")
+	result.Thumbnails = extractThumbnails(cr)
+	result.AuthorVerified = p.checkVerifiedStatus(cr)
+
+	// Channel thumbnails are usually protocol-relative urls
+	for i, thumbnail := range result.Thumbnails {
+		if strings.HasPrefix(thumbnail.URL, "//") {
+			result.Thumbnails[i].URL = "https:" + thumbnail.URL
+		}
+	}
+
+	result.VideoCount = parseCountText(getNestedString(cr, "videoCountText", "simpleText"))
+	if result.VideoCount == 0 {
+		result.VideoCount = parseCountText(getRunsText(cr, "videoCountText"))
+	}
+	result.SubscriberCount = parseCountText(getNestedString(cr, "subscriberCountText", "simpleText"))
+	return result
+}
+
+// parsePlaylistRenderer parses a playlistRenderer object into a SearchResult
+func (p *YouTubeProvider) parsePlaylistRenderer(pr map[string]interface{}) SearchResult {
+	result := SearchResult{
+		Type:       KindPlaylist,
+		PlaylistID: getString(pr, "playlistId"),
+	}
+
+	result.Title = getNestedString(pr, "title", "simpleText")
+	result.Author = getRunsText(pr, "shortBylineText")
+	result.VideoCount = getInt64(pr, "videoCount")
+
+	if browseEndpoint := getNestedMap(pr, "shortBylineText", "runs", "0", "navigationEndpoint", "browseEndpoint"); browseEndpoint != nil {
+		result.AuthorID = getString(browseEndpoint, "browseId")
+		result.AuthorURL = getString(browseEndpoint, "canonicalBaseUrl")
+	}
+
+	// Playlists contain a list of thumbnail sets, one for each of the first videos
+	if thumbnailSets := getNestedSlice(pr, "thumbnails"); len(thumbnailSets) > 0 {
+		if set, ok := thumbnailSets[0].(map[string]interface{}); ok {
+			result.Thumbnails = extractThumbnails(map[string]interface{}{"thumbnail": set})
+		}
+	}
+
+	// The first video is used for the thumbnail of the playlist
+	if videoId := getNestedString(pr, "navigationEndpoint", "watchEndpoint", "videoId"); videoId != "" {
+		result.VideoID = videoId
+	}
+	return result
+}
+
 // checkLiveStatus checks if a video is live from badges
 func (p *YouTubeProvider) checkLiveStatus(vr map[string]interface{}) bool {
 	badges, ok := vr["badges"].([]interface{})
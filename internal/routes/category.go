@@ -1,6 +1,9 @@
 package routes
 
 import (
+	"net/url"
+	"strings"
+
 	"github.com/Lekuruu/give-wii-youtube/internal/app"
 	"github.com/Lekuruu/give-wii-youtube/internal/providers"
 )
@@ -20,50 +23,77 @@ func HandleCategory(ctx *app.Context, category *app.Category) {
 
 	if category.TrendingParam == "" {
 		// Fallback to search
-		HandleCategorySearch(ctx, category.SearchFallback)
+		HandleCategorySearch(ctx, category)
 		return
 	}
 
 	// Try to resolve location metadata
 	country, language := resolveLocationMetadata(ctx.Request)
+	paging := parseFeedPaging(ctx.Request)
 
 	// Use trending param, will most likely fail though
-	results, err = ctx.State.Provider.GetTrending(category.TrendingParam, 20, country, language)
+	results, err = ctx.State.Provider.GetTrending(category.TrendingParam, paging.FetchCount(), country, language)
 
 	if err != nil || len(results) == 0 {
 		// Fallback to search
-		HandleCategorySearch(ctx, category.SearchFallback)
-		return
-	}
-
-	xml, err := providers.GenerateFeedXML(results, ctx.State.Provider.GetThumbnailUrlFormat())
-	if err != nil {
-		ctx.State.Logger.Errorf("Failed to generate feed XML: %v", err)
-		writeXMLError(ctx.Response, err.Error())
+		HandleCategorySearch(ctx, category)
 		return
 	}
 
-	ctx.Response.Header().Set("Content-Type", "text/xml; charset=utf-8")
-	ctx.Response.Write([]byte(xml))
+	writeFeed(ctx, categoryFeedOptions(ctx, category), results, paging)
 }
 
 // HandleCategorySearch uses search as fallback for categories not in trending API
-func HandleCategorySearch(ctx *app.Context, query string) {
+func HandleCategorySearch(ctx *app.Context, category *app.Category) {
 	country, language := resolveLocationMetadata(ctx.Request)
-	results, err := ctx.State.Provider.Search(query, 20, country, language)
+	paging := parseFeedPaging(ctx.Request)
+
+	results, err := ctx.State.Provider.Search(category.SearchFallback, paging.FetchCount(), country, language)
 	if err != nil {
-		ctx.State.Logger.Errorf("Category search failed for '%s': %v", query, err)
-		writeXMLError(ctx.Response, err.Error())
+		ctx.State.Logger.Errorf("Category search failed for '%s': %v", category.SearchFallback, err)
+		writeProviderError(ctx, err)
 		return
 	}
 
-	xml, err := providers.GenerateFeedXML(results, ctx.State.Provider.GetThumbnailUrlFormat())
-	if err != nil {
-		ctx.State.Logger.Errorf("Failed to generate feed XML: %v", err)
-		writeXMLError(ctx.Response, err.Error())
-		return
+	writeFeed(ctx, categoryFeedOptions(ctx, category), results, paging)
+}
+
+// categoryFeedOptions returns the feed metadata for a category
+func categoryFeedOptions(ctx *app.Context, category *app.Category) providers.FeedOptions {
+	label := category.Name
+	if label != "" {
+		label = strings.ToUpper(label[:1]) + label[1:]
 	}
 
-	ctx.Response.Header().Set("Content-Type", "text/xml; charset=utf-8")
-	ctx.Response.Write([]byte(xml))
+	options := providers.FeedOptions{
+		ID:       "tag:youtube.com,2008:standardfeed:" + strings.ToLower(category.Name),
+		Title:    label,
+		Kind:     providers.KindVideo,
+		Category: label,
+	}
+
+	// Playback settings of the category are passed on through the video urls
+	options.Formats = categoryMediaFormats(ctx, category)
+	return options
+}
+
+// categoryMediaFormats returns the served formats, whose urls request
+// the playback settings of the category if there is one
+func categoryMediaFormats(ctx *app.Context, category *app.Category) []providers.MediaFormat {
+	formats := providers.GetMediaFormats(ctx.State.Config.Video.Quality)
+	if category == nil {
+		return formats
+	}
+
+	query := url.Values{}
+	if category.AudioOnly {
+		query.Set("audio", "1")
+	}
+	if category.Loudnorm {
+		query.Set("loudnorm", "1")
+	}
+	if len(query) == 0 {
+		return formats
+	}
+	return providers.MediaFormatsWithQuery(formats, query)
 }
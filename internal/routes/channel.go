@@ -0,0 +1,71 @@
+package routes
+
+import (
+	"github.com/Lekuruu/give-wii-youtube/internal/app"
+	"github.com/Lekuruu/give-wii-youtube/internal/providers"
+)
+
+func RegisterChannelRoutes(server *app.Server) {
+	server.Router.HandleFunc("/feeds/api/users/{channel_id}/uploads", server.ContextMiddleware(HandleChannelUploads)).Methods("GET")
+
+	// The playlist search has to be registered before the playlist ids, which would match it as well
+	server.Router.HandleFunc("/feeds/api/playlists/snippets", server.ContextMiddleware(HandlePlaylistSearch)).Methods("GET")
+	server.Router.HandleFunc("/feeds/api/playlists/{playlist_id}", server.ContextMiddleware(HandlePlaylist)).Methods("GET")
+}
+
+// HandleChannelUploads returns the latest uploads of a channel as an atom feed
+func HandleChannelUploads(ctx *app.Context) {
+	channelID := ctx.Vars["channel_id"]
+	if channelID == "" {
+		writeXMLError(ctx, errBadRequest, "", "Invalid channel ID")
+		return
+	}
+
+	country, language := resolveLocationMetadata(ctx.Request)
+	paging := parseFeedPaging(ctx.Request)
+
+	results, err := ctx.State.Provider.GetChannelVideos(channelID, paging.FetchCount(), country, language)
+	if err != nil {
+		ctx.State.Logger.Errorf("Failed to get uploads for channel %s: %v", channelID, err)
+		writeProviderError(ctx, err)
+		return
+	}
+
+	channelName := channelID
+	if len(results) > 0 && results[0].Author != "" {
+		channelName = results[0].Author
+	}
+
+	feed := providers.FeedOptions{
+		ID:    "tag:youtube.com,2008:user:" + channelID + ":uploads",
+		Title: "Uploads by " + channelName,
+		Kind:  providers.KindVideo,
+	}
+	writeFeed(ctx, feed, results, paging)
+}
+
+// HandlePlaylist returns the videos of a playlist as an atom feed
+func HandlePlaylist(ctx *app.Context) {
+	playlistID := ctx.Vars["playlist_id"]
+	if playlistID == "" {
+		writeXMLError(ctx, errBadRequest, "", "Invalid playlist ID")
+		return
+	}
+
+	country, language := resolveLocationMetadata(ctx.Request)
+	paging := parseFeedPaging(ctx.Request)
+
+	results, err := ctx.State.Provider.GetPlaylistVideos(playlistID, paging.FetchCount(), country, language)
+	if err != nil {
+		ctx.State.Logger.Errorf("Failed to get videos for playlist %s: %v", playlistID, err)
+		writeProviderError(ctx, err)
+		return
+	}
+
+	feed := providers.FeedOptions{
+		ID:    "tag:youtube.com,2008:playlist:" + playlistID,
+		Title: "Playlist " + playlistID,
+		Kind:  providers.KindVideo,
+	}
+	writeFeed(ctx, feed, results, paging)
+}
@@ -0,0 +1,56 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Lekuruu/give-wii-youtube/internal/app"
+	"github.com/Lekuruu/give-wii-youtube/internal/providers"
+)
+
+// apiError is a class of gdata errors with its status code
+type apiError struct {
+	Status int
+	Domain string
+	Code   string
+}
+
+// Error classes used by the original api
+var (
+	errBadRequest = apiError{http.StatusBadRequest, "yt:validation", "invalid_value"}
+	errNotFound   = apiError{http.StatusNotFound, "GData", "ResourceNotFoundException"}
+	errQuota      = apiError{http.StatusForbidden, "yt:quota", "too_many_recent_calls"}
+	errUpstream   = apiError{http.StatusBadGateway, "GData", "ServiceUnavailableException"}
+	errInternal   = apiError{http.StatusInternalServerError, "GData", "InternalServerErrorException"}
+)
+
+// classifyError returns the error class of an error returned by a provider
+func classifyError(err error) apiError {
+	switch {
+	case errors.Is(err, providers.ErrNotFound):
+		return errNotFound
+	case errors.Is(err, providers.ErrRateLimited):
+		return errQuota
+	default:
+		return errUpstream
+	}
+}
+
+// writeXMLError writes a gdata error document with the status code of the error class,
+// where location is the name of the offending parameter, if any
+func writeXMLError(ctx *app.Context, class apiError, location string, reason string) {
+	xml, err := providers.GenerateErrorXML(class.Domain, class.Code, location, reason)
+	if err != nil {
+		ctx.State.Logger.Errorf("Failed to generate error XML: %v", err)
+		ctx.Response.WriteHeader(class.Status)
+		return
+	}
+	ctx.Response.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	ctx.Response.WriteHeader(class.Status)
+	ctx.Response.Write([]byte(xml))
+}
+
+// writeProviderError writes the gdata error document for an error returned by a provider
+func writeProviderError(ctx *app.Context, err error) {
+	writeXMLError(ctx, classifyError(err), "", err.Error())
+}
@@ -0,0 +1,241 @@
+package routes
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	ffmpeg "github.com/Lekuruu/ffmpeg-go"
+	"github.com/Lekuruu/give-wii-youtube/internal/app"
+)
+
+// errStreamRejected is returned if the source of an encode was rejected upstream,
+// e.g. because its stream url has expired early
+var errStreamRejected = errors.New("stream url rejected")
+
+// Maximum time that probing a video may take
+const probeTimeout = 30 * time.Second
+
+// FFmpegTranscoder encodes videos using ffmpeg
+type FFmpegTranscoder struct {
+	Logger *app.Logger
+}
+
+// NewFFmpegTranscoder creates a new ffmpeg transcoder
+func NewFFmpegTranscoder(logger *app.Logger) *FFmpegTranscoder {
+	return &FFmpegTranscoder{Logger: logger}
+}
+
+// Convert converts a video file or stream url into the container of the output,
+// which is written progressively so that it can be followed while it's written
+func (t *FFmpegTranscoder) Convert(input, outputPath string, output VideoOutput) error {
+	t.Logger.Logf("Converting video to %s at quality %d with profile %s: %s", output.Container, output.Height, output.Profile, input)
+
+	inputKwArgs := inputKwArgs(input)
+	outputKwArgs := encodingKwArgs(output)
+	outputKwArgs["f"] = output.Container
+	if output.Container == "webm" {
+		// Otherwise the header is rewritten at the end, after clients have already read it
+		outputKwArgs["live"] = "1"
+	}
+
+	// Files are normalized in two passes, which applies a constant gain instead of
+	// adjusting the volume on the fly, but would mean fetching stream urls twice
+	if output.Loudnorm && !strings.HasPrefix(input, "http") {
+		if measurement, err := measureLoudness(input); err == nil {
+			outputKwArgs["af"] = measurement.Filter()
+		} else {
+			t.Logger.Errorf("Failed to measure loudness of %s, normalizing in a single pass: %v", input, err)
+		}
+	}
+
+	err := ffmpegOutput(input, inputKwArgs, output, outputPath, outputKwArgs).
+		OverWriteOutput().
+		Run()
+
+	if err != nil {
+		return fmt.Errorf("ffmpeg failed: %w", err)
+	}
+
+	return nil
+}
+
+// Remux copies the streams of a progressively written file into a new file,
+// which contains the duration & cues needed for seeking
+func (t *FFmpegTranscoder) Remux(input, outputPath string, output VideoOutput) error {
+	err := ffmpeg.Input(input).
+		Output(outputPath, ffmpeg.KwArgs{"c": "copy", "f": output.Container}).
+		OverWriteOutput().
+		Run()
+
+	if err != nil {
+		return fmt.Errorf("ffmpeg failed: %w", err)
+	}
+	return nil
+}
+
+// Stream starts a constant bitrate flv encode of a stream url at the given time,
+// which keeps running until the returned reader is closed
+func (t *FFmpegTranscoder) Stream(input string, startTime float64, output VideoOutput) (io.ReadCloser, error) {
+	inputKwArgs := inputKwArgs(input)
+	outputKwArgs := encodingKwArgs(output)
+
+	// Constant bitrate & keyframe intervals keep the predicted keyframe index accurate
+	bitrate := fmt.Sprintf("%dk", output.Encoding.VideoBitrate)
+	outputKwArgs["minrate"] = bitrate
+	outputKwArgs["maxrate"] = bitrate
+	outputKwArgs["bufsize"] = bitrate
+	outputKwArgs["force_key_frames"] = fmt.Sprintf("expr:gte(t,n_forced*%d)", output.Encoding.KeyframeInterval)
+	outputKwArgs["f"] = "flv"
+
+	if startTime > 0 {
+		// Keep the timestamps relative to the start of the video
+		inputKwArgs["ss"] = fmt.Sprintf("%.2f", startTime)
+		outputKwArgs["output_ts_offset"] = fmt.Sprintf("%.2f", startTime)
+	}
+
+	// Get the command to run with pipe output
+	cmd := ffmpegOutput(input, inputKwArgs, output, "pipe:1", outputKwArgs).Compile()
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ffmpeg stdout pipe: %w", err)
+	}
+
+	stream := &ffmpegStream{cmd: cmd, stdout: stdout}
+	cmd.Stderr = &stream.stderr
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start ffmpeg: %w", err)
+	}
+	return stream, nil
+}
+
+// Probe checks a video file using ffprobe, where the files of interrupted
+// writes either can't be read at all or are missing their duration
+func (t *FFmpegTranscoder) Probe(input string) error {
+	output, err := ffmpeg.ProbeWithTimeout(input, probeTimeout, ffmpeg.KwArgs{"v": "error"})
+	if err != nil {
+		return fmt.Errorf("ffprobe failed: %w", err)
+	}
+
+	var probe struct {
+		Format struct {
+			Duration string `json:"duration"`
+		} `json:"format"`
+		Streams []json.RawMessage `json:"streams"`
+	}
+	if err := json.Unmarshal([]byte(output), &probe); err != nil {
+		return fmt.Errorf("failed to parse ffprobe output: %w", err)
+	}
+	if len(probe.Streams) == 0 {
+		return fmt.Errorf("no streams found")
+	}
+	if duration, err := strconv.ParseFloat(probe.Format.Duration, 64); err != nil || duration <= 0 {
+		return fmt.Errorf("no duration found")
+	}
+	return nil
+}
+
+// ffmpegStream reads the output of a running ffmpeg process
+type ffmpegStream struct {
+	cmd    *exec.Cmd
+	stdout io.ReadCloser
+	stderr bytes.Buffer
+	done   bool
+}
+
+// Read reads the output of ffmpeg, where the end of the output
+// is reported as an error if ffmpeg has failed
+func (s *ffmpegStream) Read(p []byte) (int, error) {
+	n, err := s.stdout.Read(p)
+	if err != io.EOF || s.done {
+		return n, err
+	}
+
+	s.done = true
+	if waitErr := s.cmd.Wait(); waitErr != nil {
+		stderr := s.stderr.String()
+		if strings.Contains(stderr, "403 Forbidden") || strings.Contains(stderr, "Server returned 403") {
+			return n, fmt.Errorf("ffmpeg failed: %w: %w, stderr: %s", errStreamRejected, waitErr, stderr)
+		}
+		return n, fmt.Errorf("ffmpeg failed: %w, stderr: %s", waitErr, stderr)
+	}
+	return n, io.EOF
+}
+
+// Close stops ffmpeg, if it's still running
+func (s *ffmpegStream) Close() error {
+	if s.done {
+		return nil
+	}
+	s.done = true
+	s.cmd.Process.Kill()
+	s.cmd.Wait()
+	return nil
+}
+
+// inputKwArgs returns the input options of a video file or stream url
+func inputKwArgs(input string) ffmpeg.KwArgs {
+	kwArgs := ffmpeg.KwArgs{}
+	if strings.HasPrefix(input, "http") {
+		// Stream urls may drop the connection during long encodes, which would
+		// otherwise end the encode early without reporting an error
+		kwArgs["reconnect"] = "1"
+		kwArgs["reconnect_streamed"] = "1"
+		kwArgs["reconnect_delay_max"] = "5"
+	}
+	return kwArgs
+}
+
+// ffmpegOutput creates the ffmpeg stream of an output, where audio-only
+// outputs replace the video with a looped still image of the thumbnail
+func ffmpegOutput(input string, inputKwArgs ffmpeg.KwArgs, output VideoOutput, target string, outputKwArgs ffmpeg.KwArgs) *ffmpeg.Stream {
+	source := ffmpeg.Input(input, inputKwArgs)
+	if !output.AudioOnly {
+		return source.Output(target, outputKwArgs)
+	}
+
+	image := ffmpeg.Input(output.Thumbnail, ffmpeg.KwArgs{
+		"loop":      "1",
+		"framerate": strconv.Itoa(output.Encoding.FrameRate),
+	})
+	outputKwArgs["shortest"] = ""
+	return ffmpeg.Output([]*ffmpeg.Stream{image.Video(), source.Audio()}, target, outputKwArgs)
+}
+
+// encodingKwArgs returns the ffmpeg output options for the encoding settings of an output
+func encodingKwArgs(output VideoOutput) ffmpeg.KwArgs {
+	encoding := output.Encoding
+	kwargs := ffmpeg.KwArgs{
+		"vf":  fmt.Sprintf("scale=-2:%d", output.Height),
+		"c:v": encoding.VideoCodec,
+		"b:v": fmt.Sprintf("%dk", encoding.VideoBitrate),
+		"r":   strconv.Itoa(encoding.FrameRate),
+		"g":   strconv.Itoa(encoding.GOP()),
+		"c:a": encoding.AudioCodec,
+		"b:a": fmt.Sprintf("%dk", encoding.AudioBitrate),
+	}
+	if encoding.SampleRate > 0 {
+		kwargs["ar"] = strconv.Itoa(encoding.SampleRate)
+	}
+	if encoding.AudioChannels > 0 {
+		kwargs["ac"] = strconv.Itoa(encoding.AudioChannels)
+	}
+	if output.Loudnorm {
+		kwargs["af"] = loudnormFilter()
+		if encoding.SampleRate <= 0 {
+			kwargs["ar"] = strconv.Itoa(loudnormSampleRate)
+		}
+	}
+	for key, value := range encoding.Options {
+		kwargs[key] = value
+	}
+	return kwargs
+}
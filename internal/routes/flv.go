@@ -0,0 +1,313 @@
+package routes
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+// Flv tag types
+const (
+	flvTagAudio  = 8
+	flvTagVideo  = 9
+	flvTagScript = 18
+)
+
+// Duration that is assumed if the length of a video is unknown
+var flvFallbackDuration = 3600
+
+// Difference in seconds between the last tag of a complete encode and the duration of the video
+const flvDurationTolerance = 1
+
+// flvHeader is the file header of an flv with audio & video, including the first "previous tag size"
+var flvHeader = []byte{'F', 'L', 'V', 0x01, 0x05, 0x00, 0x00, 0x00, 0x09, 0x00, 0x00, 0x00, 0x00}
+
+// flvIndex predicts the layout of a constant bitrate flv encode,
+// which allows us to map byte positions to keyframes before encoding
+type flvIndex struct {
+	Format         VideoOutput
+	Duration       float64
+	BytesPerSecond float64
+	metadata       []byte
+
+	// Set if the duration of the video is unknown and the fallback duration was assumed
+	Estimated bool
+}
+
+// newFLVIndex creates the index of a video with the given duration in seconds
+func newFLVIndex(format VideoOutput, duration int) *flvIndex {
+	estimated := duration <= 0
+	if estimated {
+		duration = flvFallbackDuration
+	}
+
+	// Every audio & video frame comes with a tag header and "previous tag size" field,
+	// where mp3 frames hold 1152 samples
+	sampleRate := format.Encoding.SampleRate
+	if sampleRate <= 0 {
+		sampleRate = 44100
+	}
+	tagsPerSecond := float64(format.Encoding.FrameRate) + float64(sampleRate)/1152.0
+	overhead := tagsPerSecond * (11 + 4)
+
+	index := &flvIndex{
+		Format:         format,
+		Duration:       float64(duration),
+		BytesPerSecond: float64(format.VideoBitrate+format.AudioBitrate)*1000/8 + overhead,
+		Estimated:      estimated,
+	}
+
+	// The size of the metadata doesn't depend on the values inside,
+	// so we can encode it once to know the offset of the first tag
+	index.metadata = index.encodeMetadata(0)
+	index.metadata = index.encodeMetadata(int64(len(index.metadata)))
+	return index
+}
+
+// HeaderSize returns the size of the flv header including the metadata tag
+func (i *flvIndex) HeaderSize() int64 {
+	return int64(len(flvHeader) + len(i.metadata))
+}
+
+// ContentLength returns the predicted size of the whole file
+func (i *flvIndex) ContentLength() int64 {
+	return i.HeaderSize() + int64(math.Ceil(i.Duration*i.BytesPerSecond))
+}
+
+// Header returns the flv header followed by the "onMetaData" tag
+func (i *flvIndex) Header() []byte {
+	return append(append([]byte{}, flvHeader...), i.metadata...)
+}
+
+// Keyframes returns the times of all keyframes in seconds
+func (i *flvIndex) Keyframes() []float64 {
+	interval := max(i.Format.Encoding.KeyframeInterval, 1)
+	count := int(math.Ceil(i.Duration / float64(interval)))
+	times := make([]float64, max(count, 1))
+	for k := range times {
+		times[k] = float64(k * interval)
+	}
+	return times
+}
+
+// Position returns the byte position of the keyframe at the given time
+func (i *flvIndex) Position(time float64) int64 {
+	return i.HeaderSize() + int64(time*i.BytesPerSecond)
+}
+
+// KeyframeAt returns the time of the last keyframe at or before a byte position
+func (i *flvIndex) KeyframeAt(position int64) float64 {
+	keyframes := i.Keyframes()
+	k := sort.Search(len(keyframes), func(k int) bool {
+		return i.Position(keyframes[k]) > position
+	})
+	return keyframes[max(k-1, 0)]
+}
+
+// encodeMetadata encodes the "onMetaData" script tag, which contains
+// the keyframe index that flash players use for seeking
+func (i *flvIndex) encodeMetadata(metadataSize int64) []byte {
+	keyframes := i.Keyframes()
+	headerSize := int64(len(flvHeader)) + metadataSize
+	positions := make([]float64, len(keyframes))
+	for k, time := range keyframes {
+		positions[k] = float64(headerSize + int64(time*i.BytesPerSecond))
+	}
+	width := (i.Format.Height*16/9 + 1) / 2 * 2
+
+	var data bytes.Buffer
+	writeAMFString(&data, "onMetaData")
+	data.WriteByte(0x08) // ECMA array
+	binary.Write(&data, binary.BigEndian, uint32(12))
+	writeAMFProperty(&data, "duration", i.Duration)
+	writeAMFProperty(&data, "width", float64(width))
+	writeAMFProperty(&data, "height", float64(i.Format.Height))
+	writeAMFProperty(&data, "videodatarate", float64(i.Format.VideoBitrate))
+	writeAMFProperty(&data, "audiodatarate", float64(i.Format.AudioBitrate))
+	writeAMFProperty(&data, "framerate", float64(i.Format.Encoding.FrameRate))
+	writeAMFProperty(&data, "videocodecid", float64(flvVideoCodecID(i.Format.Encoding.VideoCodec)))
+	writeAMFProperty(&data, "audiocodecid", float64(flvAudioCodecID(i.Format.Encoding.AudioCodec)))
+	writeAMFProperty(&data, "filesize", float64(headerSize+int64(math.Ceil(i.Duration*i.BytesPerSecond))))
+	writeAMFProperty(&data, "canSeekToEnd", true)
+	writeAMFProperty(&data, "hasKeyframes", true)
+
+	writeAMFKey(&data, "keyframes")
+	data.WriteByte(0x03) // Object
+	writeAMFProperty(&data, "filepositions", positions)
+	writeAMFProperty(&data, "times", keyframes)
+	data.Write([]byte{0x00, 0x00, 0x09})
+
+	data.Write([]byte{0x00, 0x00, 0x09})
+	return encodeFLVTag(flvTagScript, 0, data.Bytes())
+}
+
+// flvVideoCodecID returns the flv codec id of an ffmpeg video encoder
+func flvVideoCodecID(codec string) int {
+	switch codec {
+	case "libx264", "h264":
+		return 7 // Avc
+	case "vp6", "vp6f":
+		return 4 // On2 VP6
+	default:
+		return 2 // Sorenson H.263
+	}
+}
+
+// flvAudioCodecID returns the flv codec id of an ffmpeg audio encoder
+func flvAudioCodecID(codec string) int {
+	switch codec {
+	case "aac", "libfdk_aac":
+		return 10 // Aac
+	case "nellymoser":
+		return 6 // Nellymoser
+	default:
+		return 2 // Mp3
+	}
+}
+
+// encodeFLVTag encodes a tag including its "previous tag size" field
+func encodeFLVTag(tagType byte, timestamp uint32, data []byte) []byte {
+	tag := make([]byte, 11, 11+len(data)+4)
+	tag[0] = tagType
+	tag[1], tag[2], tag[3] = byte(len(data)>>16), byte(len(data)>>8), byte(len(data))
+	tag[4], tag[5], tag[6] = byte(timestamp>>16), byte(timestamp>>8), byte(timestamp)
+	tag[7] = byte(timestamp >> 24)
+	tag = append(tag, data...)
+	return binary.BigEndian.AppendUint32(tag, uint32(11+len(data)))
+}
+
+func writeAMFKey(w *bytes.Buffer, key string) {
+	binary.Write(w, binary.BigEndian, uint16(len(key)))
+	w.WriteString(key)
+}
+
+func writeAMFString(w *bytes.Buffer, value string) {
+	w.WriteByte(0x02)
+	writeAMFKey(w, value)
+}
+
+func writeAMFProperty(w *bytes.Buffer, key string, value interface{}) {
+	writeAMFKey(w, key)
+
+	switch v := value.(type) {
+	case float64:
+		w.WriteByte(0x00)
+		binary.Write(w, binary.BigEndian, v)
+	case bool:
+		w.WriteByte(0x01)
+		if v {
+			w.WriteByte(0x01)
+		} else {
+			w.WriteByte(0x00)
+		}
+	case []float64:
+		w.WriteByte(0x0a) // Strict array
+		binary.Write(w, binary.BigEndian, uint32(len(v)))
+		for _, number := range v {
+			w.WriteByte(0x00)
+			binary.Write(w, binary.BigEndian, number)
+		}
+	}
+}
+
+// errLimitReached is returned if the stream was cut off after the requested amount of bytes
+var errLimitReached = errors.New("limit reached")
+
+// errStreamShort is returned if the stream ended before the requested amount of bytes
+var errStreamShort = errors.New("stream ended early")
+
+// copyFLVTags copies the audio & video tags of an flv stream, skipping the
+// file header & script tags of the encoder, until limit bytes were written.
+// Streams that end early are padded with zeros up to the limit, since it was already sent
+// as the Content-Length, and reported with errStreamShort. Returns the timestamp of the
+// last tag in seconds, and errLimitReached if the stream was cut off at the limit.
+func copyFLVTags(dst io.Writer, src io.Reader, limit int64) (float64, error) {
+	header := make([]byte, len(flvHeader))
+	if _, err := io.ReadFull(src, header); err != nil {
+		return 0, fmt.Errorf("failed to read flv header: %w", err)
+	}
+
+	tagHeader := make([]byte, 11)
+	endTime := float64(0)
+	for limit > 0 {
+		if _, err := io.ReadFull(src, tagHeader); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				if err := writeZeros(dst, limit); err != nil {
+					return endTime, err
+				}
+				return endTime, errStreamShort
+			}
+			return endTime, err
+		}
+
+		size := int64(tagHeader[1])<<16 | int64(tagHeader[2])<<8 | int64(tagHeader[3])
+		tag := io.MultiReader(bytes.NewReader(tagHeader), io.LimitReader(src, size+4))
+
+		if tagHeader[0] != flvTagAudio && tagHeader[0] != flvTagVideo {
+			if _, err := io.Copy(io.Discard, tag); err != nil {
+				return endTime, err
+			}
+			continue
+		}
+
+		timestamp := uint32(tagHeader[7])<<24 | uint32(tagHeader[4])<<16 | uint32(tagHeader[5])<<8 | uint32(tagHeader[6])
+		endTime = float64(timestamp) / 1000
+
+		n, err := io.Copy(dst, io.LimitReader(tag, limit))
+		limit -= n
+		if err != nil {
+			return endTime, err
+		}
+	}
+
+	// The stream may have ended right at the limit, which still makes it complete
+	if _, err := io.ReadFull(src, tagHeader[:1]); err != io.EOF {
+		if err != nil {
+			return endTime, err
+		}
+		return endTime, errLimitReached
+	}
+	return endTime, nil
+}
+
+// findFLVKeyframe returns the offset of the first video keyframe tag at or after the given time in seconds
+func findFLVKeyframe(file io.ReadSeeker, time float64) (int64, error) {
+	target := uint32(time * 1000)
+	offset := int64(len(flvHeader))
+	tagHeader := make([]byte, 12)
+
+	for {
+		if _, err := file.Seek(offset, io.SeekStart); err != nil {
+			return 0, err
+		}
+		if _, err := io.ReadFull(file, tagHeader); err != nil {
+			return 0, fmt.Errorf("no keyframe found at %.2fs: %w", time, err)
+		}
+
+		size := int64(tagHeader[1])<<16 | int64(tagHeader[2])<<8 | int64(tagHeader[3])
+		timestamp := uint32(tagHeader[7])<<24 | uint32(tagHeader[4])<<16 | uint32(tagHeader[5])<<8 | uint32(tagHeader[6])
+		isKeyframe := tagHeader[0] == flvTagVideo && tagHeader[11]>>4 == 1
+
+		if isKeyframe && timestamp >= target {
+			return offset, nil
+		}
+		offset += 11 + size + 4
+	}
+}
+
+// writeZeros writes n zero bytes to dst
+func writeZeros(dst io.Writer, n int64) error {
+	buf := make([]byte, min(n, 32*1024))
+	for n > 0 {
+		written, err := dst.Write(buf[:min(n, int64(len(buf)))])
+		if err != nil {
+			return err
+		}
+		n -= int64(written)
+	}
+	return nil
+}
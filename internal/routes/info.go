@@ -25,21 +25,28 @@ func HandleGetVideoInfo(ctx *app.Context) {
 	info, err := ctx.State.Provider.GetVideoInfo(videoID, country, language)
 	if err != nil {
 		ctx.State.Logger.Errorf("Failed to get video info for %s: %v", videoID, err)
-		ctx.Response.WriteHeader(http.StatusInternalServerError)
+		ctx.Response.WriteHeader(classifyError(err).Status)
 		ctx.Response.Write([]byte("status=fail&errorcode=100&reason=Unable+to+fetch+video+info"))
 		return
 	}
 
+	// Videos of categories with playback settings are played the same way as in their feed
+	category := ctx.State.Categories.GetVideoCategory(info.Category)
+
 	ctx.Response.Header().Set("Content-Type", "text/plain; charset=utf-8")
-	ctx.Response.Write([]byte(info.ToVideoInfoResponse(ctx.State.Provider.GetThumbnailUrlFormat())))
+	ctx.Response.Write([]byte(info.ToVideoInfoResponse(
+		ctx.State.Provider.GetThumbnailUrlFormat(),
+		ctx.State.Config.Server.Url,
+		language,
+		categoryMediaFormats(ctx, category),
+	)))
 }
 
-// HandleVideoFeed returns video info as an atom xml entry
+// HandleVideoFeed returns video info as an atom xml entry, or as json through the "alt" parameter
 func HandleVideoFeed(ctx *app.Context) {
 	videoID := ctx.Vars["video_id"]
 	if videoID == "" {
-		ctx.Response.WriteHeader(http.StatusBadRequest)
-		writeXMLError(ctx.Response, "Invalid video ID")
+		writeXMLError(ctx, errBadRequest, "", "Invalid video ID")
 		return
 	}
 
@@ -47,19 +54,31 @@ func HandleVideoFeed(ctx *app.Context) {
 	info, err := ctx.State.Provider.GetVideoInfo(videoID, country, language)
 	if err != nil {
 		ctx.State.Logger.Errorf("Failed to get video feed for %s: %v", videoID, err)
-		ctx.Response.WriteHeader(http.StatusInternalServerError)
-		writeXMLError(ctx.Response, err.Error())
+		writeProviderError(ctx, err)
 		return
 	}
+	ctx.State.Provider.PopulateRatings(info, country, language)
+
+	generate := info.ToXMLEntry
+	format := resolveFeedFormat(ctx.Request)
+
+	switch format {
+	case FeedFormatJSON:
+		generate = info.ToJSONEntry
+	case FeedFormatJSONC:
+		generate = info.ToJSONCEntry
+	}
 
-	xml, err := info.ToXMLEntry(ctx.State.Provider.GetThumbnailUrlFormat())
+	document, err := generate(
+		ctx.State.Provider.GetThumbnailUrlFormat(),
+		ctx.State.Config.Server.Url,
+		categoryMediaFormats(ctx, ctx.State.Categories.GetVideoCategory(info.Category)),
+	)
 	if err != nil {
-		ctx.State.Logger.Errorf("Failed to generate video XML: %v", err)
-		ctx.Response.WriteHeader(http.StatusInternalServerError)
-		writeXMLError(ctx.Response, err.Error())
+		ctx.State.Logger.Errorf("Failed to generate %s video entry: %v", format, err)
+		writeXMLError(ctx, errInternal, "", err.Error())
 		return
 	}
 
-	ctx.Response.Header().Set("Content-Type", "text/xml; charset=utf-8")
-	ctx.Response.Write([]byte(xml))
+	writeFeedDocument(ctx, format, document)
 }
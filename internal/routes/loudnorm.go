@@ -0,0 +1,73 @@
+package routes
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	ffmpeg "github.com/Lekuruu/ffmpeg-go"
+)
+
+// EBU R128 targets of the loudness normalization
+const (
+	loudnormIntegrated = -16.0 // Integrated loudness in LUFS
+	loudnormTruePeak   = -1.5  // Maximum true peak in dBTP
+	loudnormRange      = 11.0  // Loudness range in LU
+)
+
+// Sample rate of normalized audio, since the loudnorm filter upsamples to 192kHz
+const loudnormSampleRate = 48000
+
+// loudnessMeasurement holds the values measured by the first loudnorm pass
+type loudnessMeasurement struct {
+	InputI       string `json:"input_i"`
+	InputTP      string `json:"input_tp"`
+	InputLRA     string `json:"input_lra"`
+	InputThresh  string `json:"input_thresh"`
+	TargetOffset string `json:"target_offset"`
+}
+
+// loudnormFilter returns the single-pass loudnorm filter, which adjusts
+// the volume dynamically since the loudness of the whole video is unknown
+func loudnormFilter() string {
+	return fmt.Sprintf("loudnorm=I=%.1f:TP=%.1f:LRA=%.1f", loudnormIntegrated, loudnormTruePeak, loudnormRange)
+}
+
+// Filter returns the second-pass loudnorm filter, which applies
+// a constant gain based on the measured loudness
+func (m *loudnessMeasurement) Filter() string {
+	return fmt.Sprintf(
+		"%s:measured_I=%s:measured_TP=%s:measured_LRA=%s:measured_thresh=%s:offset=%s:linear=true",
+		loudnormFilter(), m.InputI, m.InputTP, m.InputLRA, m.InputThresh, m.TargetOffset,
+	)
+}
+
+// measureLoudness runs the first loudnorm pass over the audio of a file
+func measureLoudness(inputPath string) (*loudnessMeasurement, error) {
+	var stderr bytes.Buffer
+	err := ffmpeg.Input(inputPath).
+		Output("-", ffmpeg.KwArgs{
+			"af": loudnormFilter() + ":print_format=json",
+			"vn": "",
+			"f":  "null",
+		}).
+		WithErrorOutput(&stderr).
+		Run()
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg failed: %w", err)
+	}
+
+	// The measurement is printed as the last json object of the output
+	output := stderr.Bytes()
+	start := bytes.LastIndexByte(output, '{')
+	end := bytes.LastIndexByte(output, '}')
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("no loudness measurement in ffmpeg output")
+	}
+
+	var measurement loudnessMeasurement
+	if err := json.Unmarshal(output[start:end+1], &measurement); err != nil {
+		return nil, fmt.Errorf("failed to parse loudness measurement: %w", err)
+	}
+	return &measurement, nil
+}
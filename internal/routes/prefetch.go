@@ -0,0 +1,147 @@
+package routes
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Lekuruu/give-wii-youtube/internal/app"
+	"github.com/Lekuruu/give-wii-youtube/internal/providers"
+)
+
+// Interval in which the prefetcher checks whether the workers are free again
+const prefetchIdleInterval = 5 * time.Second
+
+// Share of the video cache that prefetched videos may fill up
+const prefetchCacheShare = 0.8
+
+// Prefetcher converts the top videos of every category ahead of time, so that
+// videos shown on the home screen start playing instantly
+type Prefetcher struct {
+	State    *app.State
+	Streamer *VideoStreamer
+	Count    int // Amount of videos per category
+	interval time.Duration
+	stopChan chan struct{}
+}
+
+// NewPrefetcher creates a new prefetcher
+func NewPrefetcher(state *app.State, streamer *VideoStreamer, count int, interval time.Duration) *Prefetcher {
+	return &Prefetcher{
+		State:    state,
+		Streamer: streamer,
+		Count:    count,
+		interval: interval,
+		stopChan: make(chan struct{}),
+	}
+}
+
+// Start begins the prefetch scheduler
+func (p *Prefetcher) Start() {
+	go func() {
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+
+		// Initial prefetch
+		p.prefetch()
+
+		for {
+			select {
+			case <-ticker.C:
+				p.prefetch()
+			case <-p.stopChan:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the prefetch scheduler
+func (p *Prefetcher) Stop() {
+	close(p.stopChan)
+}
+
+// prefetch converts the top videos of all categories, one at a time
+func (p *Prefetcher) prefetch() {
+	format, ok := providers.DefaultMediaFormat(providers.GetMediaFormats(p.Streamer.Quality), false)
+	if !ok {
+		return
+	}
+	output := newVideoOutput(format, p.State.Profiles.DefaultProfile())
+
+	for _, category := range p.State.Categories.Entries {
+		results, err := p.categoryVideos(&category)
+		if err != nil {
+			p.State.Logger.Errorf("Failed to prefetch category '%s': %v", category.Name, err)
+			continue
+		}
+
+		for _, result := range results {
+			output := p.categoryOutput(output, &category, result.VideoID)
+			if p.Streamer.exists(p.Streamer.formatFile(result.VideoID, output)) {
+				continue
+			}
+			if !p.waitForIdle() {
+				return
+			}
+			if p.cacheFull() {
+				p.State.Logger.Log("Video cache is almost full, stopping prefetch")
+				return
+			}
+
+			videoUrl := fmt.Sprintf(p.State.Provider.GetVideoUrlFormat(), result.VideoID)
+			job := p.Streamer.submitConversion(videoUrl, result.VideoID, output)
+			select {
+			case <-job.Done():
+			case <-p.stopChan:
+				return
+			}
+		}
+	}
+}
+
+// categoryOutput applies the playback settings of a category to the output of a video,
+// the same way the video urls of the category feed request them
+func (p *Prefetcher) categoryOutput(output VideoOutput, category *app.Category, videoID string) VideoOutput {
+	if category.AudioOnly {
+		output = output.withAudioOnly(fmt.Sprintf(p.State.Provider.GetThumbnailUrlFormat(), videoID))
+	}
+	if category.Loudnorm {
+		output = output.withLoudnorm()
+	}
+	return output
+}
+
+// categoryVideos resolves the top videos of a category, the same way the category feeds do
+func (p *Prefetcher) categoryVideos(category *app.Category) ([]providers.SearchResult, error) {
+	if category.TrendingParam != "" {
+		results, err := p.State.Provider.GetTrending(category.TrendingParam, p.Count, "US", "en")
+		if err == nil && len(results) > 0 {
+			return results[:min(len(results), p.Count)], nil
+		}
+	}
+
+	results, err := p.State.Provider.Search(category.SearchFallback, p.Count, "US", "en")
+	if err != nil {
+		return nil, err
+	}
+	return results[:min(len(results), p.Count)], nil
+}
+
+// waitForIdle waits until no other jobs are running, so that requests
+// of clients always take priority over prefetching
+func (p *Prefetcher) waitForIdle() bool {
+	for p.Streamer.Jobs.Active() > 0 {
+		select {
+		case <-time.After(prefetchIdleInterval):
+		case <-p.stopChan:
+			return false
+		}
+	}
+	return true
+}
+
+// cacheFull returns whether prefetching more videos would push other videos out of the cache
+func (p *Prefetcher) cacheFull() bool {
+	cache := p.Streamer.Cache
+	return cache.MaxSize > 0 && float64(cache.Size()) >= float64(cache.MaxSize)*prefetchCacheShare
+}
@@ -0,0 +1,112 @@
+package routes
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/Lekuruu/give-wii-youtube/internal/app"
+)
+
+// Interval in which growing files are checked for new data
+const followInterval = 250 * time.Millisecond
+
+// partPath returns the path of the temporary file that is written to
+func partPath(path string) string {
+	return path + ".part"
+}
+
+// serveProgressive serves the output of a job while it is still being written.
+// Once the job has finished, the complete file is served with range support instead.
+func (vs *VideoStreamer) serveProgressive(ctx *app.Context, job *app.Job, outputFile app.StorageFile, contentType string) {
+	vs.Cache.Acquire(outputFile)
+	defer vs.Cache.Release(outputFile)
+
+	outputPath := partPath(vs.workPath(outputFile))
+
+	ticker := time.NewTicker(followInterval)
+	defer ticker.Stop()
+
+	// Wait for the job to start writing its output
+	for job.Info().Status != app.JobConverting || !fileExists(outputPath) {
+		select {
+		case <-job.Done():
+			vs.serveFinished(ctx, job, outputFile, contentType)
+			return
+		case <-ctx.Request.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+
+	file, err := os.Open(outputPath)
+	if err != nil {
+		// The job may have finished right in between
+		vs.serveFinished(ctx, job, outputFile, contentType)
+		return
+	}
+	defer file.Close()
+
+	// The final size is unknown, so the response is sent chunked
+	ctx.Response.Header().Set("Content-Type", contentType)
+	ctx.Response.WriteHeader(http.StatusOK)
+
+	buf := make([]byte, 32*1024)
+	finished := false
+
+	for {
+		n, err := file.Read(buf)
+		if n > 0 {
+			if _, writeErr := ctx.Response.Write(buf[:n]); writeErr != nil {
+				// Client disconnected
+				return
+			}
+			if flusher, ok := ctx.Response.(http.Flusher); ok {
+				flusher.Flush()
+			}
+		}
+		if err != nil && err != io.EOF {
+			vs.Logger.Errorf("Error reading %s: %v", file.Name(), err)
+			return
+		}
+		if err == nil {
+			continue
+		}
+
+		// Reached the current end of the file
+		if finished {
+			return
+		}
+
+		select {
+		case <-job.Done():
+			// Read whatever was written after the last read, the file handle
+			// stays valid after the job has removed or stored the file
+			finished = true
+		case <-ctx.Request.Context().Done():
+			return
+		case <-ticker.C:
+			switch job.Info().Status {
+			case app.JobConverting:
+			case app.JobDone:
+				// The job is marked as done right before its done channel is closed
+				finished = true
+			default:
+				// The conversion has failed or was abandoned for another
+				// attempt, whose output can't continue this response
+				return
+			}
+		}
+	}
+}
+
+// serveFinished serves the output of a finished job
+func (vs *VideoStreamer) serveFinished(ctx *app.Context, job *app.Job, outputFile app.StorageFile, contentType string) {
+	if err := job.Wait(ctx.Request.Context()); err != nil {
+		ctx.Response.WriteHeader(http.StatusInternalServerError)
+		ctx.Response.Write([]byte("Failed to process video"))
+		return
+	}
+	vs.serveFile(ctx, outputFile, contentType)
+}
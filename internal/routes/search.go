@@ -1,7 +1,7 @@
 package routes
 
 import (
-	"net/http"
+	"strings"
 
 	"github.com/Lekuruu/give-wii-youtube/internal/app"
 	"github.com/Lekuruu/give-wii-youtube/internal/providers"
@@ -10,28 +10,38 @@ import (
 func RegisterSearchRoutes(server *app.Server) {
 	server.Router.HandleFunc("/complete/search", server.ContextMiddleware(HandleSearchSuggestions)).Methods("GET")
 	server.Router.HandleFunc("/feeds/api/videos", server.ContextMiddleware(HandleVideoSearch)).Methods("GET")
+	server.Router.HandleFunc("/feeds/api/channels", server.ContextMiddleware(HandleChannelSearch)).Methods("GET")
 }
 
 // HandleSearchSuggestions handles search suggestion requests
 func HandleSearchSuggestions(ctx *app.Context) {
 	query := ctx.Request.URL.Query().Get("q")
 	if query == "" {
-		ctx.Response.WriteHeader(http.StatusBadRequest)
+		writeXMLError(ctx, errBadRequest, "q", "Missing search query")
 		return
 	}
 
 	country, language := resolveLocationMetadata(ctx.Request)
-	suggestion, err := ctx.State.Provider.GetSearchSuggestions(query, country, language)
-	if err != nil {
-		ctx.State.Logger.Errorf("Failed to fetch suggestions: %v", err)
-		ctx.Response.WriteHeader(http.StatusInternalServerError)
-		return
+	suggestions, ok := ctx.State.Suggestions.Lookup(query, country, language)
+
+	if !ok {
+		suggestion, err := ctx.State.Provider.GetSearchSuggestions(query, country, language)
+		if err != nil {
+			ctx.State.Logger.Errorf("Failed to fetch suggestions: %v", err)
+			writeProviderError(ctx, err)
+			return
+		}
+		suggestions = suggestion.Suggestions
+		ctx.State.Suggestions.Store(query, country, language, suggestions)
 	}
 
-	xml, err := providers.GenerateSuggestionsXML(query, suggestion.Suggestions)
+	// Include queries that were popular on this server
+	suggestions = ctx.State.Suggestions.Merge(query, suggestions)
+
+	xml, err := providers.GenerateSuggestionsXML(query, suggestions)
 	if err != nil {
 		ctx.State.Logger.Errorf("Failed to generate suggestions XML: %v", err)
-		ctx.Response.WriteHeader(http.StatusInternalServerError)
+		writeXMLError(ctx, errInternal, "", err.Error())
 		return
 	}
 
@@ -39,31 +49,76 @@ func HandleSearchSuggestions(ctx *app.Context) {
 	ctx.Response.Write([]byte(xml))
 }
 
-// HandleVideoSearch handles video search requests
+// HandleVideoSearch handles video search requests, optionally including
+// channels & playlists through the "include" parameter, e.g. "include=channel,playlist"
 func HandleVideoSearch(ctx *app.Context) {
 	query := ctx.Request.URL.Query().Get("q")
 	if query == "" {
-		ctx.Response.WriteHeader(http.StatusBadRequest)
-		writeXMLError(ctx.Response, "Missing search query")
+		writeXMLError(ctx, errBadRequest, "q", "Missing search query")
 		return
 	}
 
-	results, err := ctx.State.Provider.Search(query, 20, "US", "en")
+	kinds := []string{providers.KindVideo}
+	for _, kind := range strings.Split(ctx.Request.URL.Query().Get("include"), ",") {
+		switch strings.TrimSuffix(strings.TrimSpace(kind), "s") {
+		case providers.KindChannel:
+			kinds = append(kinds, providers.KindChannel)
+		case providers.KindPlaylist:
+			kinds = append(kinds, providers.KindPlaylist)
+		}
+	}
+
+	paging := parseFeedPaging(ctx.Request)
+	results, err := ctx.State.Provider.SearchKinds(query, kinds, paging.FetchCount(), "US", "en")
 	if err != nil {
 		ctx.State.Logger.Errorf("Search failed for query '%s': %v", query, err)
-		ctx.Response.WriteHeader(http.StatusInternalServerError)
-		writeXMLError(ctx.Response, err.Error())
+		writeProviderError(ctx, err)
 		return
 	}
 
-	xml, err := providers.GenerateFeedXML(results, ctx.State.Provider.GetThumbnailUrlFormat())
+	if len(results) > 0 {
+		ctx.State.Suggestions.RecordSearch(query)
+	}
+
+	feed := providers.FeedOptions{
+		Title: "Videos matching: " + query,
+		Kind:  providers.KindVideo,
+	}
+	writeFeed(ctx, feed, results, paging)
+}
+
+// HandleChannelSearch handles channel search requests
+func HandleChannelSearch(ctx *app.Context) {
+	handleKindSearch(ctx, providers.KindChannel)
+}
+
+// HandlePlaylistSearch handles playlist search requests
+func HandlePlaylistSearch(ctx *app.Context) {
+	handleKindSearch(ctx, providers.KindPlaylist)
+}
+
+// handleKindSearch performs a search that only returns results of a single kind
+func handleKindSearch(ctx *app.Context, kind string) {
+	query := ctx.Request.URL.Query().Get("q")
+	if query == "" {
+		writeXMLError(ctx, errBadRequest, "q", "Missing search query")
+		return
+	}
+
+	country, language := resolveLocationMetadata(ctx.Request)
+	paging := parseFeedPaging(ctx.Request)
+
+	results, err := ctx.State.Provider.SearchKinds(query, []string{kind}, paging.FetchCount(), country, language)
 	if err != nil {
-		ctx.State.Logger.Errorf("Failed to generate feed XML: %v", err)
-		ctx.Response.WriteHeader(http.StatusInternalServerError)
-		writeXMLError(ctx.Response, err.Error())
+		ctx.State.Logger.Errorf("%s search failed for query '%s': %v", kind, query, err)
+		writeProviderError(ctx, err)
 		return
 	}
 
-	ctx.Response.Header().Set("Content-Type", "text/xml; charset=utf-8")
-	ctx.Response.Write([]byte(xml))
+	feed := providers.FeedOptions{
+		ID:    "tag:youtube.com,2008:" + kind + "s",
+		Title: strings.ToUpper(kind[:1]) + kind[1:] + "s matching: " + query,
+		Kind:  kind,
+	}
+	writeFeed(ctx, feed, results, paging)
 }
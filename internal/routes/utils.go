@@ -3,21 +3,133 @@ package routes
 import (
 	"net/http"
 	"os"
+	"regexp"
+	"strconv"
 	"strings"
 
+	"github.com/Lekuruu/give-wii-youtube/internal/app"
 	"github.com/Lekuruu/give-wii-youtube/internal/providers"
 )
 
-func writeXMLError(w http.ResponseWriter, message string) {
-	xml, err := providers.GenerateErrorXML(message)
+// feedPaging holds the paging parameters of a feed request
+type feedPaging struct {
+	StartIndex int
+	MaxResults int
+}
+
+// parseFeedPaging parses the "start-index" & "max-results" parameters of a feed request
+func parseFeedPaging(request *http.Request) feedPaging {
+	paging := feedPaging{StartIndex: 1, MaxResults: providers.DefaultItemsPerPage}
+	query := request.URL.Query()
+
+	if startIndex, err := strconv.Atoi(query.Get("start-index")); err == nil && startIndex > 0 {
+		paging.StartIndex = startIndex
+	}
+	if maxResults, err := strconv.Atoi(query.Get("max-results")); err == nil && maxResults > 0 {
+		paging.MaxResults = min(maxResults, providers.MaxItemsPerPage)
+	}
+	return paging
+}
+
+// FetchCount returns the amount of results requested from the provider, which is one more
+// than needed to fill the page, so that we can tell whether there is a next page
+func (p feedPaging) FetchCount() int {
+	return p.EndIndex() + 1
+}
+
+// EndIndex returns the index of the last result on the page
+func (p feedPaging) EndIndex() int {
+	return p.StartIndex - 1 + p.MaxResults
+}
+
+// writeFeed renders the requested page of the results in the format requested
+// through the "alt" parameter and writes it to the response
+func writeFeed(ctx *app.Context, feed providers.FeedOptions, results []providers.SearchResult, paging feedPaging) {
+	feed.BaseURL = ctx.State.Config.Server.Url
+	feed.Path = ctx.Request.URL.Path
+	feed.Query = ctx.Request.URL.Query()
+	feed.StartIndex = paging.StartIndex
+	feed.ItemsPerPage = paging.MaxResults
+	if feed.Formats == nil {
+		feed.Formats = providers.GetMediaFormats(ctx.State.Config.Video.Quality)
+	}
+
+	// Providers can't page upstream, so there is only a next page if they returned more
+	// results than fit on this one. The total is exact without a next page, a lower bound otherwise.
+	feed.HasNext = len(results) > paging.EndIndex()
+	feed.TotalResults = len(results)
+
+	start := min(paging.StartIndex-1, len(results))
+	end := min(start+paging.MaxResults, len(results))
+
+	generate := providers.GenerateFeedXML
+	format := resolveFeedFormat(ctx.Request)
+
+	switch format {
+	case FeedFormatJSON:
+		generate = providers.GenerateFeedJSON
+	case FeedFormatJSONC:
+		generate = providers.GenerateFeedJSONC
+	}
+
+	document, err := generate(results[start:end], ctx.State.Provider.GetThumbnailUrlFormat(), feed)
 	if err != nil {
-		// Fallback to simple error if templates fail
-		w.Header().Set("Content-Type", "text/xml; charset=utf-8")
-		w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><error>` + message + `</error>`))
+		ctx.State.Logger.Errorf("Failed to generate %s feed: %v", format, err)
+		writeXMLError(ctx, errInternal, "", err.Error())
 		return
 	}
-	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
-	w.Write([]byte(xml))
+
+	writeFeedDocument(ctx, format, document)
+}
+
+// Supported output formats of feed endpoints
+const (
+	FeedFormatAtom  = "atom"
+	FeedFormatJSON  = "json"
+	FeedFormatJSONC = "jsonc"
+)
+
+// Allowed names for jsonp callbacks
+var callbackPattern = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$.]*$`)
+
+// Youtube video ids, which are also used inside of file names
+var videoIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{11}$`)
+
+// isValidVideoID returns whether a string is a valid youtube video id
+func isValidVideoID(videoID string) bool {
+	return videoIDPattern.MatchString(videoID)
+}
+
+// resolveFeedFormat resolves the output format from the "alt" parameter
+func resolveFeedFormat(request *http.Request) string {
+	switch strings.ToLower(request.URL.Query().Get("alt")) {
+	case "json", "json-in-script":
+		return FeedFormatJSON
+	case "jsonc":
+		return FeedFormatJSONC
+	default:
+		return FeedFormatAtom
+	}
+}
+
+// writeFeedDocument writes a generated feed document with the content type of its format,
+// wrapping json documents into the jsonp callback if one was requested
+func writeFeedDocument(ctx *app.Context, format string, document string) {
+	if format == FeedFormatAtom {
+		ctx.Response.Header().Set("Content-Type", "text/xml; charset=utf-8")
+		ctx.Response.Write([]byte(document))
+		return
+	}
+
+	callback := ctx.Request.URL.Query().Get("callback")
+	if callback != "" && callbackPattern.MatchString(callback) {
+		ctx.Response.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+		ctx.Response.Write([]byte(callback + "(" + document + ");"))
+		return
+	}
+
+	ctx.Response.Header().Set("Content-Type", "application/json; charset=utf-8")
+	ctx.Response.Write([]byte(document))
 }
 
 func fileExists(path string) bool {
@@ -1,38 +1,136 @@
 package routes
 
 import (
-	"bytes"
-	"context"
+	"bufio"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 
-	ffmpeg "github.com/Lekuruu/ffmpeg-go"
 	"github.com/Lekuruu/give-wii-youtube/internal/app"
-	"github.com/lrstanley/go-ytdlp"
+	"github.com/Lekuruu/give-wii-youtube/internal/providers"
+)
+
+// Downloader fetches videos from the provider
+type Downloader interface {
+	// Download downloads a video at the given height into a file
+	Download(videoUrl, outputPath string, height int) error
+	// StreamUrl resolves the direct url of a video stream at the given height
+	StreamUrl(videoUrl string, height int) (string, error)
+	// ConversionUrl resolves the direct url of a video stream with at least the given height
+	ConversionUrl(videoUrl string, height int) (string, error)
+}
+
+// Transcoder encodes videos into the formats served to clients
+type Transcoder interface {
+	// Convert converts a video file or stream url into the container of the output,
+	// which is written progressively so that it can be followed while it's written
+	Convert(input, outputPath string, output VideoOutput) error
+	// Remux copies a progressively written file into a new file that supports seeking
+	Remux(input, outputPath string, output VideoOutput) error
+	// Stream starts a constant bitrate flv encode of a stream url at the given time,
+	// which keeps running until the returned reader is closed
+	Stream(input string, startTime float64, output VideoOutput) (io.ReadCloser, error)
+	// Probe checks that a video file is complete & readable
+	Probe(input string) error
+}
+
+// Storage buckets of downloaded & converted videos
+const (
+	DownloadBucket = "downloads"
+	VideoBucket    = "cache/videos"
 )
 
 // VideoStreamer handles video downloading and streaming
 type VideoStreamer struct {
-	DownloadDir string
-	CacheDir    string
-	Logger      *app.Logger
-	Quality     string
+	Storage app.Storage
+	WorkDir string // Local directory for downloads & conversions in progress
+	Logger  *app.Logger
+	Quality string
+
+	// Whether webm conversions read from the stream url instead of a downloaded mp4
+	DirectStream bool
+
+	Downloader Downloader
+	Transcoder Transcoder
+
+	// Background downloads & conversions
+	Jobs *app.JobManager
+
+	// Eviction of downloaded & converted files
+	Cache *app.VideoCache
+
+	// Resolved stream urls, reused for seeking
+	StreamUrls *app.StreamUrlCache
+
+	// Durations of videos, needed for every flv request & seek
+	durations      map[string]int
+	durationsMutex sync.Mutex
+}
+
+// Maximum amount of cached video durations, after which the cache is cleared
+const maxCachedDurations = 10000
+
+// Bitrate in kbps & frame rate of the still image shown in audio-only outputs
+const (
+	audioOnlyVideoBitrate = 64
+	audioOnlyFrameRate    = 5
+)
+
+// VideoOutput is a media format, encoded with the settings of a transcoding profile
+type VideoOutput struct {
+	providers.MediaFormat
+	Profile  string
+	Encoding app.EncodingSettings
+
+	// Audio-only outputs show the thumbnail instead of the video
+	AudioOnly bool
+	Thumbnail string
 
-	// Track videos being processed
-	processing sync.Map
+	// Whether the loudness of the audio is normalized
+	Loudnorm bool
+}
+
+// newVideoOutput applies a transcoding profile to a format
+func newVideoOutput(format providers.MediaFormat, profile *app.TranscodeProfile) VideoOutput {
+	format, encoding := profile.Encoding(format)
+	return VideoOutput{MediaFormat: format, Profile: profile.Name, Encoding: encoding}
+}
+
+// withAudioOnly turns an output into the audio of the video with a still image of its thumbnail,
+// which keeps flash players working. Mp4 files are served as downloaded and stay unchanged.
+func (o VideoOutput) withAudioOnly(thumbnailUrl string) VideoOutput {
+	if o.Container == "mp4" {
+		return o
+	}
+	o.AudioOnly = true
+	o.Thumbnail = thumbnailUrl
+	o.VideoBitrate = audioOnlyVideoBitrate
+	o.Encoding.VideoBitrate = audioOnlyVideoBitrate
+	o.Encoding.FrameRate = audioOnlyFrameRate
+	return o
+}
+
+// withLoudnorm normalizes the loudness of an output. Mp4 files are served as downloaded and stay unchanged.
+func (o VideoOutput) withLoudnorm() VideoOutput {
+	if o.Container != "mp4" {
+		o.Loudnorm = true
+	}
+	return o
 }
 
 // NewVideoStreamer creates a new video streamer
-func NewVideoStreamer(downloadDir, cacheDir, quality string, logger *app.Logger) *VideoStreamer {
-	// Ensure directories exist
-	os.MkdirAll(downloadDir, 0755)
-	os.MkdirAll(cacheDir, 0755)
+func NewVideoStreamer(storage app.Storage, workDir, quality string, workers int, directStream bool, downloader Downloader, transcoder Transcoder, cache *app.VideoCache, logger *app.Logger) *VideoStreamer {
+	// Ensure the work directory exists
+	os.MkdirAll(workDir, 0755)
 
 	// Default to 360p, if not specified
 	if quality == "" {
@@ -40,82 +138,434 @@ func NewVideoStreamer(downloadDir, cacheDir, quality string, logger *app.Logger)
 	}
 
 	return &VideoStreamer{
-		DownloadDir: downloadDir,
-		CacheDir:    cacheDir,
-		Logger:      logger,
-		Quality:     quality,
+		Storage:      storage,
+		WorkDir:      workDir,
+		Logger:       logger,
+		Quality:      quality,
+		DirectStream: directStream,
+		Downloader:   downloader,
+		Transcoder:   transcoder,
+		Jobs:         app.NewJobManager(workers, logger),
+		Cache:        cache,
+		StreamUrls:   app.NewStreamUrlCache(),
+		durations:    make(map[string]int),
 	}
 }
 
+// Leftovers of downloads & conversions in the work folder, including the fragments of yt-dlp
+var staleFilePatterns = []string{"*.part", "*.part-Frag*", "*.ytdl", "*" + app.TempSuffix}
+
+// CleanWorkDir removes downloads & conversions that were interrupted by a crash,
+// since nothing can be working on them yet at startup. Other files are left alone,
+// in case the work folder is shared with something else.
+func (vs *VideoStreamer) CleanWorkDir() {
+	entries, err := os.ReadDir(vs.WorkDir)
+	if err != nil {
+		vs.Logger.Errorf("Failed to read work folder: %v", err)
+		return
+	}
+
+	removed := 0
+	for _, entry := range entries {
+		if entry.IsDir() || !isStaleFile(entry.Name()) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(vs.WorkDir, entry.Name())); err != nil {
+			vs.Logger.Errorf("Failed to remove stale file %s: %v", entry.Name(), err)
+			continue
+		}
+		removed++
+	}
+	if removed > 0 {
+		vs.Logger.Logf("Removed %d stale files from the work folder", removed)
+	}
+}
+
+// isStaleFile returns whether a file in the work folder was left behind by an interrupted job
+func isStaleFile(name string) bool {
+	for _, pattern := range staleFilePatterns {
+		if matched, _ := filepath.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
 func RegisterVideoRoutes(server *app.Server, streamer *VideoStreamer) {
 	server.Router.HandleFunc("/get_video", server.ContextMiddleware(streamer.HandleGetVideo)).Methods("GET")
 	server.Router.HandleFunc("/git_video", server.ContextMiddleware(streamer.HandleGitVideo)).Methods("GET")
 	server.Router.HandleFunc("/videos/{filename}", server.ContextMiddleware(streamer.HandleServeVideo)).Methods("GET")
+	server.Router.HandleFunc("/jobs/{video_id}", server.ContextMiddleware(streamer.HandleJobStatus)).Methods("GET")
 }
 
-// HandleGetVideo downloads video and converts to webm for Wii playback
+// HandleGetVideo downloads a video and converts it to the format requested
+// through the "fmt" parameter, defaulting to webm for Wii playback
 func (vs *VideoStreamer) HandleGetVideo(ctx *app.Context) {
 	videoID := ctx.Request.URL.Query().Get("video_id")
-	videoUrl := fmt.Sprintf(ctx.State.Provider.GetVideoUrlFormat(), videoID)
 	if videoID == "" {
 		ctx.Response.WriteHeader(http.StatusBadRequest)
 		ctx.Response.Write([]byte("Missing video_id parameter"))
 		return
 	}
 
-	// Check if webm already exists
-	webmPath := filepath.Join(vs.CacheDir, videoID+".webm")
-	if fileExists(webmPath) {
-		vs.serveFile(ctx, webmPath, "video/webm")
+	output, ok := vs.resolveOutput(ctx, videoID, false)
+	if !ok {
+		ctx.Response.WriteHeader(http.StatusBadRequest)
+		ctx.Response.Write([]byte("Unsupported fmt parameter"))
 		return
 	}
+	vs.serveConverted(ctx, videoID, output)
+}
+
+// resolveOutput resolves the format requested through the "fmt" parameter,
+// encoded with the transcoding profile of the client
+func (vs *VideoStreamer) resolveOutput(ctx *app.Context, videoID string, live bool) (VideoOutput, bool) {
+	formats := providers.GetMediaFormats(vs.Quality)
+
+	itag, err := strconv.Atoi(ctx.Request.URL.Query().Get("fmt"))
+	if err != nil {
+		format, ok := providers.DefaultMediaFormat(formats, live)
+		return vs.requestOutput(ctx, videoID, format), ok
+	}
+
+	format, ok := providers.FindMediaFormat(formats, itag)
+	if !ok || format.Live != live {
+		return VideoOutput{}, false
+	}
+	return vs.requestOutput(ctx, videoID, format), true
+}
+
+// requestOutput applies the transcoding profile of the client to a format, along with audio-only
+// playback & loudness normalization if requested through the "audio" & "loudnorm" parameters
+func (vs *VideoStreamer) requestOutput(ctx *app.Context, videoID string, format providers.MediaFormat) VideoOutput {
+	query := ctx.Request.URL.Query()
+	output := newVideoOutput(format, ctx.State.Profiles.Resolve(ctx.Request))
+	if audioOnly, _ := strconv.ParseBool(query.Get("audio")); audioOnly {
+		output = output.withAudioOnly(fmt.Sprintf(ctx.State.Provider.GetThumbnailUrlFormat(), videoID))
+	}
+	if loudnorm, _ := strconv.ParseBool(query.Get("loudnorm")); loudnorm {
+		output = output.withLoudnorm()
+	}
+	return output
+}
+
+// formatFile returns the stored file of a video in the given output
+func (vs *VideoStreamer) formatFile(videoID string, output VideoOutput) app.StorageFile {
+	if output.Container == "mp4" {
+		// Mp4 files are served as downloaded
+		return vs.downloadFile(videoID, output.Height)
+	}
+	name := fmt.Sprintf("%s_%d_%s", videoID, output.Itag, output.Profile)
+	if output.AudioOnly {
+		name += "_audio"
+	}
+	if output.Loudnorm {
+		name += "_loudnorm"
+	}
+	return app.StorageFile{Key: name + "." + output.Container, Bucket: VideoBucket}
+}
+
+// downloadFile returns the stored file of a downloaded video at the given height
+func (vs *VideoStreamer) downloadFile(videoID string, height int) app.StorageFile {
+	return app.StorageFile{Key: fmt.Sprintf("%s_%d.mp4", videoID, height), Bucket: DownloadBucket}
+}
+
+// isServedFile returns whether a downloaded file is also served as is, by one of the mp4 formats
+func (vs *VideoStreamer) isServedFile(videoID string, file app.StorageFile) bool {
+	for _, format := range providers.GetMediaFormats(vs.Quality) {
+		if format.Container == "mp4" && vs.downloadFile(videoID, format.Height) == file {
+			return true
+		}
+	}
+	return false
+}
+
+// workPath returns the local path that a stored file is written to, before it's moved into the storage
+func (vs *VideoStreamer) workPath(file app.StorageFile) string {
+	return filepath.Join(vs.WorkDir, file.Key)
+}
+
+// exists returns whether a file is in the storage, which only holds finished files.
+// Files cut off by a crash of previous versions are removed by VerifyStorage.
+func (vs *VideoStreamer) exists(file app.StorageFile) bool {
+	return vs.Storage.Exists(file.Key, file.Bucket)
+}
+
+// VerifyStorage probes the stored videos and removes the ones that can't be played,
+// which is meant to run in the background at startup, as probing every file takes a while.
+// Videos are still served until their turn comes, instead of delaying every request.
+func (vs *VideoStreamer) VerifyStorage() {
+	removed := 0
+	for _, bucket := range []string{DownloadBucket, VideoBucket} {
+		files, err := vs.Storage.List(bucket)
+		if err != nil {
+			vs.Logger.Errorf("Failed to list %s: %v", bucket, err)
+			continue
+		}
+		for _, info := range files {
+			if !vs.verify(app.StorageFile{Key: info.Key, Bucket: bucket}) {
+				removed++
+			}
+		}
+	}
+	if removed > 0 {
+		vs.Logger.Logf("Removed %d invalid cached videos", removed)
+	}
+}
+
+// verify probes a stored video, removing it if it's invalid
+func (vs *VideoStreamer) verify(file app.StorageFile) bool {
+	// The file may have been evicted in the meantime
+	if !vs.exists(file) {
+		return true
+	}
+
+	path, cleanup, err := app.FetchFile(vs.Storage, file, vs.WorkDir)
+	if err != nil {
+		vs.Logger.Errorf("Failed to read cached video %s: %v", file.Key, err)
+		return true
+	}
+	err = vs.Transcoder.Probe(path)
+	cleanup()
+
+	if err != nil {
+		vs.Logger.Errorf("Removing invalid cached video %s: %v", file.Key, err)
+		vs.Cache.Remove(file)
+		return false
+	}
+	return true
+}
 
-	// Check if already processing
-	if _, processing := vs.processing.LoadOrStore(videoID, true); processing {
-		ctx.Response.WriteHeader(http.StatusAccepted)
-		ctx.Response.Write([]byte("Video is being processed, please try again later"))
+// store moves a finished local file into the storage
+func (vs *VideoStreamer) store(path string, file app.StorageFile) error {
+	if err := app.StoreFile(vs.Storage, path, file); err != nil {
+		os.Remove(path)
+		return fmt.Errorf("failed to store %s: %w", file.Key, err)
+	}
+	vs.Cache.Add(file)
+	return nil
+}
+
+// serveConverted serves a video in the given output, waiting for it
+// to be downloaded & converted in the background first if needed
+func (vs *VideoStreamer) serveConverted(ctx *app.Context, videoID string, output VideoOutput) {
+	// Check if the output was already converted
+	outputFile := vs.formatFile(videoID, output)
+	if vs.exists(outputFile) {
+		vs.serveFile(ctx, outputFile, output.MimeType)
 		return
 	}
-	defer vs.processing.Delete(videoID)
 
-	// Download video using yt-dlp
-	mp4Path := filepath.Join(vs.DownloadDir, videoID+".mp4")
+	videoUrl := fmt.Sprintf(ctx.State.Provider.GetVideoUrlFormat(), videoID)
+	job := vs.submitConversion(videoUrl, videoID, output)
 
-	if !fileExists(mp4Path) {
-		if err := vs.downloadVideo(videoUrl, mp4Path); err != nil {
-			vs.Logger.Errorf("Failed to download video %s: %v", videoID, err)
-			ctx.Response.WriteHeader(http.StatusInternalServerError)
-			ctx.Response.Write([]byte("Failed to download video"))
+	if output.Container == "webm" {
+		// Webm files can be played while they are still being converted
+		vs.serveProgressive(ctx, job, outputFile, output.MimeType)
+		return
+	}
+
+	if err := job.Wait(ctx.Request.Context()); err != nil {
+		if ctx.Request.Context().Err() != nil {
+			// Client disconnected, the job keeps running for the next request
 			return
 		}
+		ctx.Response.WriteHeader(http.StatusInternalServerError)
+		ctx.Response.Write([]byte("Failed to process video"))
+		return
+	}
+
+	vs.serveFile(ctx, outputFile, output.MimeType)
+}
+
+// submitConversion queues the download & conversion of a video,
+// or returns the job that is already working on it
+func (vs *VideoStreamer) submitConversion(videoUrl, videoID string, output VideoOutput) *app.Job {
+	if output.Container == "mp4" {
+		// Mp4 files are served as downloaded
+		return vs.submitDownload(videoUrl, videoID, output.Height, output.Itag)
+	}
+
+	outputFile := vs.formatFile(videoID, output)
+	return vs.Jobs.Submit(outputFile.Key, videoID, output.Itag, func(job *app.Job) error {
+		return vs.processVideo(job, videoUrl, outputFile, output)
+	})
+}
+
+// submitDownload queues the download of a video at the given height, which is shared
+// by all of its conversions, or returns the job that is already downloading it
+func (vs *VideoStreamer) submitDownload(videoUrl, videoID string, height int, itag int) *app.Job {
+	sourceFile := vs.downloadFile(videoID, height)
+	return vs.Jobs.Submit(sourceFile.Key, videoID, itag, func(job *app.Job) error {
+		vs.Cache.Acquire(sourceFile)
+		defer vs.Cache.Release(sourceFile)
+
+		if vs.exists(sourceFile) {
+			return nil
+		}
+
+		job.SetStatus(app.JobDownloading)
+		downloadPath := vs.workPath(sourceFile)
+		if err := vs.Downloader.Download(videoUrl, downloadPath, height); err != nil {
+			return fmt.Errorf("failed to download video: %w", err)
+		}
+		return vs.store(downloadPath, sourceFile)
+	})
+}
+
+// processVideo downloads a video and converts it to the given output
+func (vs *VideoStreamer) processVideo(job *app.Job, videoUrl string, outputFile app.StorageFile, output VideoOutput) error {
+	sourceFile := vs.downloadFile(job.VideoID, output.Height)
+
+	// Protect the files from eviction while we are working on them
+	vs.Cache.Acquire(sourceFile)
+	defer vs.Cache.Release(sourceFile)
+	vs.Cache.Acquire(outputFile)
+	defer vs.Cache.Release(outputFile)
+
+	if output.Container == "webm" && vs.DirectStream && !vs.exists(sourceFile) {
+		err := vs.streamToWebm(job, videoUrl, outputFile, output)
+		if err == nil {
+			return nil
+		}
+		vs.Logger.Errorf("Direct conversion of %s failed, falling back to download: %v", job.VideoID, err)
+		job.SetStatus(app.JobDownloading)
+	}
+
+	if !vs.exists(sourceFile) {
+		// Other conversions of the same source wait for the same download
+		job.SetStatus(app.JobDownloading)
+		download := vs.submitDownload(videoUrl, job.VideoID, output.Height, 0)
+		if err := vs.Jobs.Await(download); err != nil {
+			return err
+		}
+	}
+
+	// Leftovers of an interrupted conversion must not be followed by clients
+	os.Remove(partPath(vs.workPath(outputFile)))
+	// ffmpeg reads the source from the disk, which may have to be copied out of the storage first
+	sourcePath, cleanup, err := app.FetchFile(vs.Storage, sourceFile, vs.WorkDir)
+	if err != nil {
+		return fmt.Errorf("failed to read source of %s: %w", job.VideoID, err)
+	}
+	job.SetStatus(app.JobConverting)
+	err = vs.convertToWebmPart(sourcePath, outputFile, output)
+	cleanup()
+	if err != nil {
+		return err
+	}
+
+	// The source is not needed anymore, unless it's served as an mp4 format
+	// or another job is still using it
+	if !vs.isServedFile(job.VideoID, sourceFile) {
+		vs.Cache.RemoveSource(sourceFile)
+	}
+	return nil
+}
+
+// streamToWebm converts a video to webm while reading it from its stream url,
+// without downloading it first
+func (vs *VideoStreamer) streamToWebm(job *app.Job, videoUrl string, outputFile app.StorageFile, output VideoOutput) error {
+	// The flv stream urls are picked for compatibility, which would have to be upscaled
+	streamUrl, err := vs.getConversionUrl(videoUrl, output.Height)
+	if err != nil {
+		return fmt.Errorf("failed to get stream url: %w", err)
+	}
+
+	os.Remove(partPath(vs.workPath(outputFile)))
+	job.SetStatus(app.JobConverting)
+	if err := vs.convertToWebmPart(streamUrl, outputFile, output); err != nil {
+		// The output of ffmpeg isn't captured here, so a rejected url can't be told apart
+		vs.StreamUrls.Invalidate(streamUrl)
+		return err
+	}
+	return nil
+}
+
+// convertToWebmPart converts a video into a temporary file, which is followed by clients
+// until it's complete, and moves it into the storage once it was remuxed for seeking
+func (vs *VideoStreamer) convertToWebmPart(input string, outputFile app.StorageFile, output VideoOutput) error {
+	outputPath := partPath(vs.workPath(outputFile))
+	if err := vs.Transcoder.Convert(input, outputPath, output); err != nil {
+		os.Remove(outputPath)
+		return fmt.Errorf("failed to convert video: %w", err)
+	}
+
+	// Clients that are still following the part file keep their file handle
+	remuxPath := vs.workPath(outputFile) + app.TempSuffix
+	if err := vs.Transcoder.Remux(outputPath, remuxPath, output); err != nil {
+		// The progressive file is still playable, just not seekable
+		vs.Logger.Errorf("Failed to remux %s: %v", outputFile.Key, err)
+		os.Remove(remuxPath)
+		return vs.store(outputPath, outputFile)
+	}
+	os.Remove(outputPath)
+	return vs.store(remuxPath, outputFile)
+}
+
+// HandleJobStatus returns the state of the background jobs of a video as json
+func (vs *VideoStreamer) HandleJobStatus(ctx *app.Context) {
+	videoID := ctx.Vars["video_id"]
+	jobs := vs.Jobs.VideoJobs(videoID)
+
+	infos := make([]app.JobInfo, len(jobs))
+	for i, job := range jobs {
+		infos[i] = job.Info()
 	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Format < infos[j].Format })
 
-	// Convert to webm
-	if err := vs.convertToWebm(mp4Path, webmPath); err != nil {
-		vs.Logger.Errorf("Failed to convert video %s: %v", videoID, err)
+	response, err := json.Marshal(map[string]interface{}{
+		"videoId": videoID,
+		"jobs":    infos,
+	})
+	if err != nil {
 		ctx.Response.WriteHeader(http.StatusInternalServerError)
-		ctx.Response.Write([]byte("Failed to convert video"))
 		return
 	}
 
-	vs.serveFile(ctx, webmPath, "video/webm")
+	ctx.Response.Header().Set("Content-Type", "application/json")
+	if len(jobs) == 0 {
+		ctx.Response.WriteHeader(http.StatusNotFound)
+	}
+	ctx.Response.Write(response)
 }
 
-// Hardcoded content length for flv streaming
-const flvContentLength = 500000000
-
-// HandleGitVideo streams video as flv with real-time transcoding
+// HandleGitVideo streams video as flv with real-time transcoding,
+// in the quality requested through the "fmt" parameter
 func (vs *VideoStreamer) HandleGitVideo(ctx *app.Context) {
-	videoID := ctx.Request.URL.Query().Get("video_id")
+	query := ctx.Request.URL.Query()
+	videoID := query.Get("video_id")
 	if videoID == "" {
 		ctx.Response.WriteHeader(http.StatusBadRequest)
 		ctx.Response.Write([]byte("Missing video_id parameter"))
 		return
 	}
 
+	output, ok := vs.resolveOutput(ctx, videoID, true)
+	if !ok {
+		ctx.Response.WriteHeader(http.StatusBadRequest)
+		ctx.Response.Write([]byte("Unsupported fmt parameter"))
+		return
+	}
+
+	// Set headers for streaming
+	ctx.Response.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.flv"`, videoID))
+	start, _ := strconv.ParseInt(query.Get("start"), 10, 64)
+
+	// Serve previous transcodes from the storage
+	cacheFile := vs.formatFile(videoID, output)
+	if vs.exists(cacheFile) {
+		if start > 0 {
+			vs.serveCachedFLVFrom(ctx, cacheFile, vs.flvIndex(ctx, videoID, output), start)
+			return
+		}
+		vs.serveFile(ctx, cacheFile, output.MimeType)
+		return
+	}
+
 	// Get direct video url using yt-dlp
 	videoUrl := fmt.Sprintf(ctx.State.Provider.GetVideoUrlFormat(), videoID)
-	streamUrl, err := vs.getStreamUrl(videoUrl)
+	streamUrl, err := vs.getStreamUrl(videoUrl, output.Height)
 	if err != nil {
 		vs.Logger.Errorf("Failed to get video url for %s: %v", videoID, err)
 		ctx.Response.WriteHeader(http.StatusInternalServerError)
@@ -123,50 +573,201 @@ func (vs *VideoStreamer) HandleGitVideo(ctx *app.Context) {
 		return
 	}
 
+	index := vs.flvIndex(ctx, videoID, output)
+	contentLength := index.ContentLength()
+
+	ctx.Response.Header().Set("Content-Type", output.MimeType)
+	ctx.Response.Header().Set("Accept-Ranges", "bytes")
+	response := flushWriter{ctx.Response}
+
+	// Flash players seek through the "start" parameter, using the file positions of the keyframe index,
+	// which is answered with a new flv header followed by the tags from that keyframe onwards
+	if start > 0 {
+		if start >= contentLength {
+			ctx.Response.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			ctx.Response.Write([]byte("Requested start not satisfiable"))
+			return
+		}
+
+		keyframe := index.KeyframeAt(start)
+		remaining := contentLength - index.Position(keyframe)
+		ctx.Response.Header().Set("Content-Length", strconv.FormatInt(int64(len(flvHeader))+remaining, 10))
+		ctx.Response.Write(flvHeader)
+		vs.streamTranscoded(ctx, response, streamUrl, keyframe, remaining, output)
+		return
+	}
+
 	// Parse range header for seeking
 	rangeHeader := ctx.Request.Header.Get("Range")
-	rangeStart := 0
-	rangeEnd := flvContentLength - 1
+	rangeStart := int64(0)
+	rangeEnd := contentLength - 1
 
 	if rangeHeader != "" {
-		var start, end int
+		var start, end int64
 		n, _ := fmt.Sscanf(rangeHeader, "bytes=%d-%d", &start, &end)
 		if n >= 1 {
 			rangeStart = start
 			if n == 2 {
-				rangeEnd = end
+				rangeEnd = min(end, contentLength-1)
 			}
 		}
 
-		if rangeStart >= flvContentLength || rangeEnd >= flvContentLength {
+		if rangeStart >= contentLength || rangeStart > rangeEnd {
+			ctx.Response.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", contentLength))
 			ctx.Response.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
 			ctx.Response.Write([]byte("Requested range not satisfiable"))
 			return
 		}
+
+		ctx.Response.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", rangeStart, rangeEnd, contentLength))
+		ctx.Response.Header().Set("Content-Length", strconv.FormatInt(rangeEnd-rangeStart+1, 10))
+		ctx.Response.WriteHeader(http.StatusPartialContent)
+	} else {
+		ctx.Response.Header().Set("Content-Length", strconv.FormatInt(contentLength, 10))
 	}
 
-	// Calculate start time and duration based on byte position
-	// Approximate bitrate: 500kbps video + 96kbps audio
-	totalBitrate := float64(500000 + 96000)
-	bytesPerSecond := totalBitrate / 8
-	startTime := float64(rangeStart) / bytesPerSecond
-	duration := float64(rangeEnd-rangeStart+1) / bytesPerSecond
+	// Full playbacks are written to the cache as well
+	if rangeStart == 0 && rangeEnd == contentLength-1 {
+		vs.streamAndCacheFLV(ctx, response, streamUrl, index, cacheFile)
+		return
+	}
 
-	// Set headers for streaming
-	ctx.Response.Header().Set("Content-Type", "video/x-flv")
-	ctx.Response.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.flv"`, videoID))
-	ctx.Response.Header().Set("Accept-Ranges", "bytes")
+	// Ranges inside of the header are served from the prepared header,
+	// the rest is transcoded starting at the keyframe before the range
+	remaining := rangeEnd - rangeStart + 1
+	startTime := float64(0)
 
-	if rangeHeader != "" {
-		ctx.Response.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", rangeStart, rangeEnd, flvContentLength))
-		ctx.Response.Header().Set("Content-Length", strconv.Itoa(rangeEnd-rangeStart+1))
-		ctx.Response.WriteHeader(http.StatusPartialContent)
-		vs.streamWithFFmpeg(ctx, streamUrl, startTime, duration)
+	if header := index.Header(); rangeStart < int64(len(header)) {
+		chunk := header[rangeStart:min(rangeEnd+1, int64(len(header)))]
+		if _, err := response.Write(chunk); err != nil {
+			return
+		}
+		remaining -= int64(len(chunk))
+	} else {
+		startTime = index.KeyframeAt(rangeStart)
+	}
+
+	if remaining > 0 {
+		vs.streamTranscoded(ctx, response, streamUrl, startTime, remaining, output)
+	}
+}
+
+// flvIndex creates the keyframe index of a video, which needs the duration of the video
+func (vs *VideoStreamer) flvIndex(ctx *app.Context, videoID string, output VideoOutput) *flvIndex {
+	return newFLVIndex(output, vs.videoDuration(ctx, videoID))
+}
+
+// videoDuration returns the duration of a video in seconds, which is cached
+// since seeking clients request it over and over again
+func (vs *VideoStreamer) videoDuration(ctx *app.Context, videoID string) int {
+	vs.durationsMutex.Lock()
+	duration, ok := vs.durations[videoID]
+	vs.durationsMutex.Unlock()
+	if ok {
+		return duration
+	}
+
+	country, language := resolveLocationMetadata(ctx.Request)
+	info, err := ctx.State.Provider.GetVideoInfo(videoID, country, language)
+	if err != nil {
+		vs.Logger.Errorf("Failed to get duration of %s: %v", videoID, err)
+		return 0
+	}
+
+	vs.durationsMutex.Lock()
+	if len(vs.durations) >= maxCachedDurations {
+		vs.durations = make(map[string]int)
+	}
+	vs.durations[videoID] = info.LengthSeconds
+	vs.durationsMutex.Unlock()
+	return info.LengthSeconds
+}
+
+// streamAndCacheFLV streams a whole flv file while writing it into the cache,
+// where the cached file is discarded unless the stream was completed
+func (vs *VideoStreamer) streamAndCacheFLV(ctx *app.Context, response io.Writer, streamUrl string, index *flvIndex, cacheFile app.StorageFile) {
+	header := index.Header()
+	remaining := index.ContentLength() - int64(len(header))
+	cachePath := partPath(vs.workPath(cacheFile))
+
+	// Only one request at a time writes the cache, others just stream
+	file, err := os.OpenFile(cachePath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		if _, err := response.Write(header); err == nil {
+			vs.streamTranscoded(ctx, response, streamUrl, 0, remaining, index.Format)
+		}
+		return
+	}
+
+	// Encodes that don't match their predicted size are either cut off or padded, and ffmpeg
+	// may end early without an error if the upstream connection drops, so only encodes that
+	// fill the predicted size and reach the known duration of the video end up in the cache
+	dst := io.MultiWriter(response, file)
+	completed := false
+	if _, err := dst.Write(header); err == nil {
+		endTime, err := vs.streamTranscoded(ctx, dst, streamUrl, 0, remaining, index.Format)
+		switch {
+		case err == errLimitReached:
+			vs.Logger.Logf("Not caching %s, the encode exceeded its predicted size", cacheFile.Key)
+		case err == errStreamShort:
+			vs.Logger.Logf("Not caching %s, the encode ended before its predicted size", cacheFile.Key)
+		case err == nil && index.Estimated:
+			vs.Logger.Logf("Not caching %s, the duration of the video is unknown", cacheFile.Key)
+		case err == nil && endTime < index.Duration-flvDurationTolerance:
+			vs.Logger.Logf("Not caching %s, the encode ended at %.2fs of %.0fs", cacheFile.Key, endTime, index.Duration)
+		default:
+			completed = err == nil
+		}
+	}
+
+	if err := file.Close(); err != nil {
+		completed = false
+	}
+	if !completed {
+		os.Remove(cachePath)
+		return
+	}
+	if err := vs.store(cachePath, cacheFile); err != nil {
+		vs.Logger.Errorf("Failed to cache flv: %v", err)
+	}
+}
+
+// serveCachedFLVFrom answers a "start" seek request from a cached flv file,
+// with a new flv header followed by the tags from the keyframe at the position
+func (vs *VideoStreamer) serveCachedFLVFrom(ctx *app.Context, cacheFile app.StorageFile, index *flvIndex, start int64) {
+	vs.Cache.Acquire(cacheFile)
+	defer vs.Cache.Release(cacheFile)
+
+	stat, err := vs.Storage.Stat(cacheFile.Key, cacheFile.Bucket)
+	if err != nil {
+		ctx.Response.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	file, err := vs.Storage.ReadStream(cacheFile.Key, cacheFile.Bucket)
+	if err != nil {
+		ctx.Response.WriteHeader(http.StatusNotFound)
 		return
 	}
+	defer file.Close()
 
-	ctx.Response.Header().Set("Content-Length", strconv.Itoa(flvContentLength))
-	vs.streamWithFFmpeg(ctx, streamUrl, startTime, duration)
+	// The keyframes of the real encode are close to, but not exactly at the predicted positions,
+	// so we look for the keyframe with the predicted timestamp instead
+	offset, err := findFLVKeyframe(file, index.KeyframeAt(start))
+	if err != nil {
+		vs.Logger.Errorf("Failed to seek in %s: %v", cacheFile.Key, err)
+		ctx.Response.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		ctx.Response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	ctx.Response.Header().Set("Content-Type", "video/x-flv")
+	ctx.Response.Header().Set("Content-Length", strconv.FormatInt(int64(len(flvHeader))+stat.Size-offset, 10))
+	ctx.Response.Write(flvHeader)
+	io.Copy(ctx.Response, file)
 }
 
 // HandleServeVideo serves a cached video file
@@ -180,13 +781,20 @@ func (vs *VideoStreamer) HandleServeVideo(ctx *app.Context) {
 	// Sanitize filename to prevent directory traversal
 	filename = filepath.Base(filename)
 
-	// Try cache directory first, then download directory
-	filePath := filepath.Join(vs.CacheDir, filename)
-	if !fileExists(filePath) {
-		filePath = filepath.Join(vs.DownloadDir, filename)
+	// Try converted videos first, then downloaded ones
+	file := app.StorageFile{Key: filename, Bucket: VideoBucket}
+	if !vs.exists(file) {
+		file.Bucket = DownloadBucket
 	}
 
-	if !fileExists(filePath) {
+	if !vs.exists(file) {
+		// Webm files are advertised in feeds before they exist, so convert them on demand
+		if videoID, ok := strings.CutSuffix(filename, ".webm"); ok && isValidVideoID(videoID) {
+			if format, ok := providers.DefaultMediaFormat(providers.GetMediaFormats(vs.Quality), false); ok {
+				vs.serveConverted(ctx, videoID, vs.requestOutput(ctx, videoID, format))
+				return
+			}
+		}
 		ctx.Response.WriteHeader(http.StatusNotFound)
 		return
 	}
@@ -200,178 +808,101 @@ func (vs *VideoStreamer) HandleServeVideo(ctx *app.Context) {
 		contentType = "video/x-flv"
 	}
 
-	vs.serveFile(ctx, filePath, contentType)
+	vs.serveFile(ctx, file, contentType)
 }
 
-// downloadVideo downloads a video using yt-dlp
-func (vs *VideoStreamer) downloadVideo(videoUrl, outputPath string) error {
-	vs.Logger.Logf("Downloading video %s at quality %s", videoUrl, vs.Quality)
-
-	dl := ytdlp.New().
-		FormatSort(fmt.Sprintf("res:%s,ext:mp4:m4a", vs.Quality)).
-		NoPlaylist().
-		NoOverwrites().
-		Continue().
-		Output(outputPath)
-
-	_, err := dl.Run(context.Background(), videoUrl)
-	if err != nil {
-		return fmt.Errorf("yt-dlp failed: %w", err)
-	}
-
-	return nil
+// getStreamUrl gets a direct video url for flv streaming, reusing
+// previously resolved urls until they expire
+func (vs *VideoStreamer) getStreamUrl(videoUrl string, height int) (string, error) {
+	key := fmt.Sprintf("%s@%d", videoUrl, height)
+	return vs.cachedUrl(key, func() (string, error) {
+		return vs.Downloader.StreamUrl(videoUrl, height)
+	})
 }
 
-// getStreamUrl gets a direct video url for flv streaming
-func (vs *VideoStreamer) getStreamUrl(videoUrl string) (string, error) {
-	dl := ytdlp.New().
-		Format(fmt.Sprintf("5/18/best[ext=mp4]/best[height<=%s]", vs.Quality)).
-		NoPlaylist().
-		Print("urls")
-
-	result, err := dl.Run(context.Background(), videoUrl)
-	if err != nil {
-		return "", fmt.Errorf("yt-dlp failed: %w", err)
-	}
-
-	url := strings.TrimSpace(result.Stdout)
-	if url == "" {
-		return "", fmt.Errorf("no url returned")
-	}
-
-	// yt-dlp may return multiple urls, take the first one
-	lines := strings.Split(url, "\n")
-	return lines[0], nil
+// getConversionUrl gets a direct video url for converting a video of the given height
+// from the stream, reusing previously resolved urls until they expire
+func (vs *VideoStreamer) getConversionUrl(videoUrl string, height int) (string, error) {
+	key := fmt.Sprintf("%s@%d+", videoUrl, height)
+	return vs.cachedUrl(key, func() (string, error) {
+		return vs.Downloader.ConversionUrl(videoUrl, height)
+	})
 }
 
-// convertToWebm converts a video file to webm format optimized for Wii
-func (vs *VideoStreamer) convertToWebm(inputPath, outputPath string) error {
-	vs.Logger.Logf("Converting video to webm at quality %s: %s", vs.Quality, inputPath)
-
-	// Calculate bitrate based on quality
-	bitrate := "300k"
-	qualityInt, err := strconv.Atoi(vs.Quality)
-	if err == nil {
-		if qualityInt >= 720 {
-			bitrate = "1000k"
-		} else if qualityInt >= 480 {
-			bitrate = "500k"
-		}
+// cachedUrl returns the cached stream url for a key, or resolves & caches it
+func (vs *VideoStreamer) cachedUrl(key string, resolve func() (string, error)) (string, error) {
+	if streamUrl, ok := vs.StreamUrls.Get(key); ok {
+		return streamUrl, nil
 	}
 
-	err = ffmpeg.Input(inputPath).
-		Output(outputPath, ffmpeg.KwArgs{
-			"vf":       fmt.Sprintf("scale=-1:%s", vs.Quality),
-			"c:v":      "libvpx",
-			"b:v":      bitrate,
-			"cpu-used": "8",
-			"pix_fmt":  "yuv420p",
-			"c:a":      "libvorbis",
-			"b:a":      "128k",
-			"r":        "30",
-			"g":        "30",
-		}).
-		OverWriteOutput().
-		Run()
-
+	streamUrl, err := resolve()
 	if err != nil {
-		return fmt.Errorf("ffmpeg failed: %w", err)
+		return "", err
 	}
-
-	return nil
+	vs.StreamUrls.Store(key, streamUrl)
+	return streamUrl, nil
 }
 
-// streamWithFFmpeg streams video content through FFmpeg transcoding (hardcoded 240p for Wii)
-func (vs *VideoStreamer) streamWithFFmpeg(ctx *app.Context, streamUrl string, startTime, duration float64) {
-	inputKwArgs := ffmpeg.KwArgs{}
-	if startTime > 0 {
-		inputKwArgs["ss"] = fmt.Sprintf("%.2f", startTime)
-	}
-
-	outputKwArgs := ffmpeg.KwArgs{
-		"c:v": "flv1",
-		"b:v": "500k",
-		"vf":  "scale=-1:240",
-		"c:a": "mp3",
-		"b:a": "96k",
-		"r":   "24",
-		"g":   "24",
-		"f":   "flv",
-	}
-	if duration > 0 {
-		outputKwArgs["t"] = fmt.Sprintf("%.2f", duration)
-	}
-
-	// Create the ffmpeg stream
-	stream := ffmpeg.Input(streamUrl, inputKwArgs).
-		Output("pipe:1", outputKwArgs)
-
-	// Get the command to run with pipe output
-	cmd := stream.Compile()
-
-	stdout, err := cmd.StdoutPipe()
+// streamTranscoded streams the audio & video tags of a constant bitrate flv encode,
+// starting at the given time, until limit bytes were written or the encode has finished.
+// Returns the timestamp of the last tag in seconds, and errLimitReached or errStreamShort if the
+// encode didn't match the limit, or another error if the stream was interrupted.
+func (vs *VideoStreamer) streamTranscoded(ctx *app.Context, dst io.Writer, streamUrl string, startTime float64, limit int64, output VideoOutput) (float64, error) {
+	stream, err := vs.Transcoder.Stream(streamUrl, startTime, output)
 	if err != nil {
-		vs.Logger.Errorf("Failed to create FFmpeg stdout pipe: %v", err)
-		ctx.Response.WriteHeader(http.StatusInternalServerError)
-		return
+		vs.Logger.Errorf("Failed to start transcoding: %v", err)
+		return 0, err
 	}
+	// Stops the encode, which may still be running since its size is only predicted
+	defer stream.Close()
 
-	// Capture stderr for debugging
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
-
-	if err := cmd.Start(); err != nil {
-		vs.Logger.Errorf("Failed to start FFmpeg: %v", err)
-		ctx.Response.WriteHeader(http.StatusInternalServerError)
-		return
+	endTime, err := copyFLVTags(dst, bufio.NewReaderSize(stream, 32*1024), limit)
+	if err == errLimitReached || err == errStreamShort {
+		return endTime, err
 	}
-
-	// Stream output to response (32KB buffer)
-	buf := make([]byte, 32*1024)
-
-	for {
-		n, err := stdout.Read(buf)
-		if n > 0 {
-			if _, writeErr := ctx.Response.Write(buf[:n]); writeErr != nil {
-				// Client disconnected
-				break
-			}
-
-			if flusher, ok := ctx.Response.(http.Flusher); ok {
-				// Flush if possible
-				flusher.Flush()
-			}
-		}
-		if err != nil {
-			if err != io.EOF {
-				vs.Logger.Errorf("Error reading FFmpeg output: %v, stderr: %s", err, stderr.String())
-			}
-			break
+	if err != nil && ctx.Request.Context().Err() == nil {
+		vs.Logger.Errorf("Error streaming transcoded video: %v", err)
+		if errors.Is(err, errStreamRejected) {
+			vs.StreamUrls.Invalidate(streamUrl)
 		}
 	}
+	return endTime, err
+}
 
-	// Clean up
-	cmd.Wait()
+// flushWriter flushes the response after every write
+type flushWriter struct {
+	w http.ResponseWriter
+}
+
+func (fw flushWriter) Write(p []byte) (int, error) {
+	n, err := fw.w.Write(p)
+	if flusher, ok := fw.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return n, err
 }
 
 // serveFile serves a file with proper headers and range support
-func (vs *VideoStreamer) serveFile(ctx *app.Context, filePath, contentType string) {
-	file, err := os.Open(filePath)
+func (vs *VideoStreamer) serveFile(ctx *app.Context, storageFile app.StorageFile, contentType string) {
+	vs.Cache.Acquire(storageFile)
+	defer vs.Cache.Release(storageFile)
+
+	stat, err := vs.Storage.Stat(storageFile.Key, storageFile.Bucket)
 	if err != nil {
 		ctx.Response.WriteHeader(http.StatusNotFound)
 		return
 	}
-	defer file.Close()
 
-	stat, err := file.Stat()
+	file, err := vs.Storage.ReadStream(storageFile.Key, storageFile.Bucket)
 	if err != nil {
-		ctx.Response.WriteHeader(http.StatusInternalServerError)
+		ctx.Response.WriteHeader(http.StatusNotFound)
 		return
 	}
+	defer file.Close()
 
 	ctx.Response.Header().Set("Content-Type", contentType)
 	ctx.Response.Header().Set("Accept-Ranges", "bytes")
-	ctx.Response.Header().Set("Content-Length", strconv.FormatInt(stat.Size(), 10))
+	ctx.Response.Header().Set("Content-Length", strconv.FormatInt(stat.Size, 10))
 
 	// Handle range requests
 	rangeHeader := ctx.Request.Header.Get("Range")
@@ -381,20 +912,20 @@ func (vs *VideoStreamer) serveFile(ctx *app.Context, filePath, contentType strin
 		parts := strings.Split(ranges, "-")
 
 		start, _ := strconv.ParseInt(parts[0], 10, 64)
-		end := stat.Size() - 1
+		end := stat.Size - 1
 
 		if len(parts) > 1 && parts[1] != "" {
 			end, _ = strconv.ParseInt(parts[1], 10, 64)
 		}
 
-		if start > end || start >= stat.Size() {
+		if start > end || start >= stat.Size {
 			ctx.Response.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
 			return
 		}
 
 		contentLength := end - start + 1
 		ctx.Response.Header().Set("Content-Length", strconv.FormatInt(contentLength, 10))
-		ctx.Response.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, stat.Size()))
+		ctx.Response.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, stat.Size))
 		ctx.Response.WriteHeader(http.StatusPartialContent)
 
 		file.Seek(start, io.SeekStart)
@@ -402,5 +933,5 @@ func (vs *VideoStreamer) serveFile(ctx *app.Context, filePath, contentType strin
 		return
 	}
 
-	http.ServeContent(ctx.Response, ctx.Request, filepath.Base(filePath), stat.ModTime(), file)
+	http.ServeContent(ctx.Response, ctx.Request, path.Base(storageFile.Key), stat.ModTime, file)
 }
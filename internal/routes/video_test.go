@@ -0,0 +1,584 @@
+package routes
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Lekuruu/give-wii-youtube/internal/app"
+	"github.com/Lekuruu/give-wii-youtube/internal/providers"
+)
+
+// fakeDownloader writes the video url into the downloaded file
+type fakeDownloader struct {
+	mu        sync.Mutex
+	downloads int
+	resolves  int
+	err       error
+	gate      chan struct{} // Blocks downloads until closed, if set
+}
+
+func (d *fakeDownloader) Download(videoUrl, outputPath string, height int) error {
+	d.mu.Lock()
+	d.downloads++
+	d.mu.Unlock()
+
+	if d.gate != nil {
+		<-d.gate
+	}
+	if d.err != nil {
+		return d.err
+	}
+	return os.WriteFile(outputPath, []byte("source:"+videoUrl), 0644)
+}
+
+func (d *fakeDownloader) StreamUrl(videoUrl string, height int) (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.resolves++
+	return "fake://" + videoUrl, d.err
+}
+
+func (d *fakeDownloader) ConversionUrl(videoUrl string, height int) (string, error) {
+	return d.StreamUrl(videoUrl, height)
+}
+
+func (d *fakeDownloader) counts() (int, int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.downloads, d.resolves
+}
+
+// fakeTranscoder prefixes the input with the container, and streams a fixed flv file
+type fakeTranscoder struct {
+	mu          sync.Mutex
+	conversions int
+	streams     int
+	streamErr   error
+	probeErr    error
+
+	// Size of an additional video tag, for streams that exceed their predicted size
+	streamPadding int
+
+	// Length of the encode in seconds, for streams that end before their predicted size
+	streamSeconds int
+}
+
+// Length of the videos returned by the fake provider in seconds
+const fakeVideoDuration = 10
+
+func (t *fakeTranscoder) Convert(input, outputPath string, output VideoOutput) error {
+	t.mu.Lock()
+	t.conversions++
+	t.mu.Unlock()
+
+	data, err := os.ReadFile(input)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(outputPath, append([]byte(output.Container+":"), data...), 0644)
+}
+
+func (t *fakeTranscoder) Remux(input, outputPath string, output VideoOutput) error {
+	data, err := os.ReadFile(input)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(outputPath, data, 0644)
+}
+
+func (t *fakeTranscoder) Stream(input string, startTime float64, output VideoOutput) (io.ReadCloser, error) {
+	t.mu.Lock()
+	t.streams++
+	streamErr := t.streamErr
+	streamPadding := t.streamPadding
+	streamSeconds := t.streamSeconds
+	t.mu.Unlock()
+
+	if streamErr != nil {
+		return io.NopCloser(&failingReader{streamErr}), nil
+	}
+	if streamSeconds > 0 {
+		return io.NopCloser(bytes.NewReader(fakeFLV(startTime, streamSeconds))), nil
+	}
+	// Complete encodes are filled up to their predicted size with a final audio tag
+	seconds := fakeVideoDuration - int(startTime)
+	data := fakeFLV(startTime, seconds)
+	index := newFLVIndex(output, fakeVideoDuration)
+	// The flv header & script tag of the encoder aren't part of the predicted size
+	copied := len(data) - len(flvHeader) - len(encodeFLVTag(flvTagScript, 0, []byte("metadata")))
+	fill := index.ContentLength() - index.Position(startTime) - int64(copied) - 11 - 4
+	timestamp := uint32((startTime + float64(seconds-1)) * 1000)
+	data = append(data, encodeFLVTag(flvTagAudio, timestamp, make([]byte, fill))...)
+
+	if streamPadding > 0 {
+		data = append(data, encodeFLVTag(flvTagVideo, timestamp, make([]byte, streamPadding))...)
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (t *fakeTranscoder) Probe(input string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.probeErr
+}
+
+func (t *fakeTranscoder) counts() (int, int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.conversions, t.streams
+}
+
+type failingReader struct{ err error }
+
+func (r *failingReader) Read(p []byte) (int, error) { return 0, r.err }
+
+// fakeFLV encodes an flv file with a script tag, followed by
+// a video keyframe & audio tag for every second
+func fakeFLV(startTime float64, seconds int) []byte {
+	data := append([]byte{}, flvHeader...)
+	data = append(data, encodeFLVTag(flvTagScript, 0, []byte("metadata"))...)
+	for i := 0; i < seconds; i++ {
+		timestamp := uint32((startTime + float64(i)) * 1000)
+		data = append(data, encodeFLVTag(flvTagVideo, timestamp, []byte{0x12, 0x00, 0x00})...)
+		data = append(data, encodeFLVTag(flvTagAudio, timestamp, []byte{0x2f, 0x00})...)
+	}
+	return data
+}
+
+// assertContentLength checks that the body of a response matches its Content-Length header
+func assertContentLength(t *testing.T, response *httptest.ResponseRecorder) {
+	t.Helper()
+	contentLength, err := strconv.Atoi(response.Header().Get("Content-Length"))
+	if err != nil {
+		t.Fatalf("invalid Content-Length %q", response.Header().Get("Content-Length"))
+	}
+	if response.Body.Len() != contentLength {
+		t.Fatalf("expected %d bytes, got %d", contentLength, response.Body.Len())
+	}
+}
+
+// fakeProvider answers the few provider calls made by the video routes
+type fakeProvider struct {
+	providers.Provider
+	infoErr error
+}
+
+func (p *fakeProvider) GetVideoInfo(videoId, country, language string) (*providers.VideoInfo, error) {
+	if p.infoErr != nil {
+		return nil, p.infoErr
+	}
+	return &providers.VideoInfo{VideoID: videoId, LengthSeconds: fakeVideoDuration}, nil
+}
+
+func (p *fakeProvider) GetVideoUrlFormat() string {
+	return "https://www.youtube.com/watch?v=%s"
+}
+
+func (p *fakeProvider) GetThumbnailUrlFormat() string {
+	return "http://i.ytimg.com/vi/%s/hqdefault.jpg"
+}
+
+type videoTestServer struct {
+	server     *app.Server
+	streamer   *VideoStreamer
+	downloader *fakeDownloader
+	transcoder *fakeTranscoder
+}
+
+func newVideoTestServer(t *testing.T) *videoTestServer {
+	t.Helper()
+
+	logger := app.NewLogger("test")
+	state := &app.State{
+		Config:   &app.Config{},
+		Logger:   logger,
+		Provider: &fakeProvider{},
+		Profiles: app.DefaultProfiles(),
+	}
+
+	dir := t.TempDir()
+	storage := &app.FileStorage{BasePath: filepath.Join(dir, "storage"), Logger: logger}
+	cache := app.NewVideoCache(storage, []string{DownloadBucket, VideoBucket}, 0, 0, false, logger)
+
+	workDir := filepath.Join(dir, "work")
+	if err := os.MkdirAll(workDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	downloader := &fakeDownloader{}
+	transcoder := &fakeTranscoder{}
+	streamer := NewVideoStreamer(storage, workDir, "360", 2, false, downloader, transcoder, cache, logger)
+
+	server := app.NewServer("127.0.0.1", 0, "test", state)
+	RegisterVideoRoutes(server, streamer)
+
+	return &videoTestServer{
+		server:     server,
+		streamer:   streamer,
+		downloader: downloader,
+		transcoder: transcoder,
+	}
+}
+
+func (s *videoTestServer) get(path string, header http.Header) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodGet, path, nil)
+	for key, values := range header {
+		request.Header[key] = values
+	}
+	recorder := httptest.NewRecorder()
+	s.server.Router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestGetVideoConvertsOnce(t *testing.T) {
+	s := newVideoTestServer(t)
+	expected := "webm:source:https://www.youtube.com/watch?v=abc"
+
+	response := s.get("/get_video?video_id=abc&fmt=43", nil)
+	if response.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", response.Code)
+	}
+	if body := response.Body.String(); body != expected {
+		t.Fatalf("expected body %q, got %q", expected, body)
+	}
+
+	// The second request is served from the cache
+	response = s.get("/get_video?video_id=abc&fmt=43", nil)
+	if body := response.Body.String(); body != expected {
+		t.Fatalf("expected cached body %q, got %q", expected, body)
+	}
+
+	downloads, _ := s.downloader.counts()
+	conversions, _ := s.transcoder.counts()
+	if downloads != 1 || conversions != 1 {
+		t.Fatalf("expected 1 download & conversion, got %d & %d", downloads, conversions)
+	}
+}
+
+func TestGetVideoReplacesInvalidCache(t *testing.T) {
+	s := newVideoTestServer(t)
+	s.transcoder.probeErr = errors.New("no duration found")
+
+	// A truncated conversion left behind by a crash
+	if err := s.streamer.Storage.Save("abc_43_wii.webm", VideoBucket, []byte("webm:sou")); err != nil {
+		t.Fatal(err)
+	}
+
+	s.streamer.VerifyStorage()
+	if s.streamer.Storage.Exists("abc_43_wii.webm", VideoBucket) {
+		t.Fatal("invalid cached video was not removed")
+	}
+
+	response := s.get("/get_video?video_id=abc&fmt=43", nil)
+	expected := "webm:source:https://www.youtube.com/watch?v=abc"
+	if body := response.Body.String(); body != expected {
+		t.Fatalf("expected body %q, got %q", expected, body)
+	}
+
+	// The new conversion is trusted without probing it
+	response = s.get("/get_video?video_id=abc&fmt=43", nil)
+	if body := response.Body.String(); body != expected {
+		t.Fatalf("expected cached body %q, got %q", expected, body)
+	}
+	if conversions, _ := s.transcoder.counts(); conversions != 1 {
+		t.Fatalf("expected 1 conversion, got %d", conversions)
+	}
+}
+
+func TestGetVideoKeepsServedSources(t *testing.T) {
+	s := newVideoTestServer(t)
+	s.streamer.Cache.DeleteSources = true
+
+	// The download of the webm conversion is served as the mp4 format of the same height
+	s.get("/get_video?video_id=abc&fmt=43", nil)
+	if !s.streamer.Storage.Exists("abc_360.mp4", DownloadBucket) {
+		t.Fatal("source that is served as mp4 was removed")
+	}
+}
+
+func TestServeVideoRejectsInvalidIDs(t *testing.T) {
+	s := newVideoTestServer(t)
+
+	for _, filename := range []string{"abc_43_wii.webm", "abcdefghijkl.webm", "abc%2Fdefghij.webm"} {
+		response := s.get("/videos/"+filename, nil)
+		if response.Code != http.StatusNotFound {
+			t.Fatalf("expected status 404 for %s, got %d", filename, response.Code)
+		}
+	}
+	if downloads, _ := s.downloader.counts(); downloads != 0 {
+		t.Fatalf("expected no downloads, got %d", downloads)
+	}
+}
+
+func TestGetVideoRangeRequest(t *testing.T) {
+	s := newVideoTestServer(t)
+	s.get("/get_video?video_id=abc&fmt=43", nil)
+
+	response := s.get("/get_video?video_id=abc&fmt=43", http.Header{"Range": {"bytes=0-4"}})
+	if response.Code != http.StatusPartialContent {
+		t.Fatalf("expected status 206, got %d", response.Code)
+	}
+	if body := response.Body.String(); body != "webm:" {
+		t.Fatalf("expected body %q, got %q", "webm:", body)
+	}
+}
+
+func TestGetVideoConcurrentRequests(t *testing.T) {
+	s := newVideoTestServer(t)
+	s.downloader.gate = make(chan struct{})
+
+	const requests = 5
+	bodies := make([]string, requests)
+	var wg sync.WaitGroup
+
+	for i := range requests {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			bodies[i] = s.get("/get_video?video_id=abc&fmt=43", nil).Body.String()
+		}()
+	}
+
+	// Let the requests pile up on the running download
+	time.Sleep(100 * time.Millisecond)
+	close(s.downloader.gate)
+	wg.Wait()
+
+	expected := "webm:source:https://www.youtube.com/watch?v=abc"
+	for i, body := range bodies {
+		if body != expected {
+			t.Errorf("request %d: expected body %q, got %q", i, expected, body)
+		}
+	}
+
+	downloads, _ := s.downloader.counts()
+	conversions, _ := s.transcoder.counts()
+	if downloads != 1 || conversions != 1 {
+		t.Fatalf("expected 1 download & conversion, got %d & %d", downloads, conversions)
+	}
+}
+
+func TestGetVideoSharedDownload(t *testing.T) {
+	s := newVideoTestServer(t)
+	s.downloader.gate = make(chan struct{})
+
+	// Different outputs at the same height convert the same download
+	paths := []string{
+		"/get_video?video_id=abc&fmt=43",
+		"/get_video?video_id=abc&fmt=43&audio=1",
+		"/get_video?video_id=abc&fmt=43&loudnorm=1",
+		"/get_video?video_id=abc&fmt=18",
+	}
+	codes := make([]int, len(paths))
+	var wg sync.WaitGroup
+
+	for i, path := range paths {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes[i] = s.get(path, nil).Code
+		}()
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	close(s.downloader.gate)
+	wg.Wait()
+
+	for i, code := range codes {
+		if code != http.StatusOK {
+			t.Errorf("%s: expected status 200, got %d", paths[i], code)
+		}
+	}
+
+	downloads, _ := s.downloader.counts()
+	conversions, _ := s.transcoder.counts()
+	if downloads != 1 || conversions != 3 {
+		t.Fatalf("expected 1 download & 3 conversions, got %d & %d", downloads, conversions)
+	}
+}
+
+func TestGetVideoDownloadFailure(t *testing.T) {
+	s := newVideoTestServer(t)
+	s.downloader.err = errors.New("video unavailable")
+
+	response := s.get("/get_video?video_id=abc&fmt=43", nil)
+	if response.Code != http.StatusInternalServerError {
+		t.Fatalf("expected status 500, got %d", response.Code)
+	}
+	if s.streamer.Storage.Exists("abc_43_wii.webm", VideoBucket) {
+		t.Fatal("failed conversion was cached")
+	}
+}
+
+func TestGetVideoInvalidFormat(t *testing.T) {
+	s := newVideoTestServer(t)
+
+	for _, path := range []string{"/get_video", "/get_video?video_id=abc&fmt=5", "/git_video?video_id=abc&fmt=43"} {
+		if response := s.get(path, nil); response.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", path, response.Code)
+		}
+	}
+}
+
+func TestGitVideoStreamsAndCaches(t *testing.T) {
+	s := newVideoTestServer(t)
+
+	response := s.get("/git_video?video_id=abc", nil)
+	if response.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", response.Code)
+	}
+	body := response.Body.Bytes()
+	if !bytes.HasPrefix(body, flvHeader) {
+		t.Fatal("response doesn't start with an flv header")
+	}
+	if bytes.Contains(body, []byte("metadata")) {
+		t.Fatal("script tag of the encoder was not skipped")
+	}
+	assertContentLength(t, response)
+
+	// The complete stream was cached, so seeking doesn't transcode again
+	response = s.get("/git_video?video_id=abc", nil)
+	if !bytes.Equal(response.Body.Bytes(), body) {
+		t.Fatal("cached response differs from the streamed one")
+	}
+	response = s.get("/git_video?video_id=abc&start=1000", nil)
+	if !bytes.HasPrefix(response.Body.Bytes(), flvHeader) {
+		t.Fatal("seek response doesn't start with an flv header")
+	}
+
+	if _, streams := s.transcoder.counts(); streams != 1 {
+		t.Fatalf("expected 1 transcode, got %d", streams)
+	}
+}
+
+func TestGitVideoDoesntCacheShortStreams(t *testing.T) {
+	s := newVideoTestServer(t)
+	s.transcoder.streamSeconds = 5
+
+	// The response is padded up to the predicted size, which must not be cached
+	response := s.get("/git_video?video_id=abc", nil)
+	if response.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", response.Code)
+	}
+	assertContentLength(t, response)
+
+	if s.streamer.Storage.Exists("abc_5_wii.flv", VideoBucket) {
+		t.Fatal("stream that ended before its predicted size was cached")
+	}
+}
+
+func TestGitVideoDoesntCacheUnknownDurations(t *testing.T) {
+	s := newVideoTestServer(t)
+	s.server.State.Provider = &fakeProvider{infoErr: errors.New("video info unavailable")}
+
+	// The encode fills the size predicted for the fallback duration,
+	// which is shortened to keep the response small
+	fallbackDuration := flvFallbackDuration
+	flvFallbackDuration = fakeVideoDuration
+	t.Cleanup(func() { flvFallbackDuration = fallbackDuration })
+
+	response := s.get("/git_video?video_id=abc", nil)
+	if response.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", response.Code)
+	}
+	assertContentLength(t, response)
+
+	if s.streamer.Storage.Exists("abc_5_wii.flv", VideoBucket) {
+		t.Fatal("stream with an unknown duration was cached")
+	}
+}
+
+func TestGitVideoDoesntCacheExceededStreams(t *testing.T) {
+	s := newVideoTestServer(t)
+	format, _ := providers.DefaultMediaFormat(providers.GetMediaFormats("360"), true)
+	index := newFLVIndex(newVideoOutput(format, s.server.State.Profiles.DefaultProfile()), fakeVideoDuration)
+	s.transcoder.streamPadding = int(index.ContentLength())
+
+	// The response is cut off at the predicted size, which leaves the encode incomplete
+	response := s.get("/git_video?video_id=abc", nil)
+	if response.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", response.Code)
+	}
+	assertContentLength(t, response)
+
+	if s.streamer.Storage.Exists("abc_5_wii.flv", VideoBucket) {
+		t.Fatal("stream that exceeded its predicted size was cached")
+	}
+}
+
+func TestGitVideoRangeRequests(t *testing.T) {
+	s := newVideoTestServer(t)
+	format, _ := providers.DefaultMediaFormat(providers.GetMediaFormats("360"), true)
+	index := newFLVIndex(newVideoOutput(format, s.server.State.Profiles.DefaultProfile()), 10)
+
+	// Ranges inside of the header are served without transcoding
+	response := s.get("/git_video?video_id=abc", http.Header{"Range": {"bytes=0-9"}})
+	if response.Code != http.StatusPartialContent {
+		t.Fatalf("expected status 206, got %d", response.Code)
+	}
+	if !bytes.Equal(response.Body.Bytes(), index.Header()[:10]) {
+		t.Fatal("range doesn't match the flv header")
+	}
+	if _, streams := s.transcoder.counts(); streams != 0 {
+		t.Fatalf("expected no transcode, got %d", streams)
+	}
+
+	// Ranges after the header start transcoding at the keyframe before them
+	start := index.Position(4)
+	response = s.get("/git_video?video_id=abc", http.Header{"Range": {fmt.Sprintf("bytes=%d-", start)}})
+	if response.Code != http.StatusPartialContent {
+		t.Fatalf("expected status 206, got %d", response.Code)
+	}
+	if _, streams := s.transcoder.counts(); streams != 1 {
+		t.Fatalf("expected 1 transcode, got %d", streams)
+	}
+
+	// The stream url is reused for every seek
+	if _, resolves := s.downloader.counts(); resolves != 1 {
+		t.Fatalf("expected 1 resolved stream url, got %d", resolves)
+	}
+
+	response = s.get("/git_video?video_id=abc", http.Header{"Range": {fmt.Sprintf("bytes=%d-", index.ContentLength())}})
+	if response.Code != http.StatusRequestedRangeNotSatisfiable {
+		t.Fatalf("expected status 416, got %d", response.Code)
+	}
+}
+
+func TestGitVideoRejectedStreamUrl(t *testing.T) {
+	s := newVideoTestServer(t)
+	s.transcoder.streamErr = fmt.Errorf("ffmpeg failed: %w", errStreamRejected)
+
+	s.get("/git_video?video_id=abc", nil)
+	s.get("/git_video?video_id=abc", nil)
+
+	// The rejected url must be resolved again
+	if _, resolves := s.downloader.counts(); resolves != 2 {
+		t.Fatalf("expected 2 resolved stream urls, got %d", resolves)
+	}
+	if s.streamer.Storage.Exists("abc_5_wii.flv", VideoBucket) {
+		t.Fatal("failed stream was cached")
+	}
+}
+
+func TestGitVideoResolveFailure(t *testing.T) {
+	s := newVideoTestServer(t)
+	s.downloader.err = errors.New("video unavailable")
+
+	response := s.get("/git_video?video_id=abc", nil)
+	if response.Code != http.StatusInternalServerError {
+		t.Fatalf("expected status 500, got %d", response.Code)
+	}
+	if _, streams := s.transcoder.counts(); streams != 0 {
+		t.Fatalf("expected no transcode, got %d", streams)
+	}
+}
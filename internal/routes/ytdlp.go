@@ -0,0 +1,78 @@
+package routes
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Lekuruu/give-wii-youtube/internal/app"
+	"github.com/lrstanley/go-ytdlp"
+)
+
+// YtdlpDownloader downloads videos & resolves their stream urls using yt-dlp
+type YtdlpDownloader struct {
+	Logger *app.Logger
+}
+
+// NewYtdlpDownloader creates a new yt-dlp downloader
+func NewYtdlpDownloader(logger *app.Logger) *YtdlpDownloader {
+	return &YtdlpDownloader{Logger: logger}
+}
+
+// Download downloads a video using yt-dlp
+func (d *YtdlpDownloader) Download(videoUrl, outputPath string, height int) error {
+	d.Logger.Logf("Downloading video %s at quality %d", videoUrl, height)
+
+	dl := ytdlp.New().
+		FormatSort(fmt.Sprintf("res:%d,ext:mp4:m4a", height)).
+		NoPlaylist().
+		NoOverwrites().
+		Continue().
+		Output(outputPath)
+
+	_, err := dl.Run(context.Background(), videoUrl)
+	if err != nil {
+		return fmt.Errorf("yt-dlp failed: %w", err)
+	}
+
+	return nil
+}
+
+// StreamUrl gets a direct video url for streaming
+func (d *YtdlpDownloader) StreamUrl(videoUrl string, height int) (string, error) {
+	dl := ytdlp.New().
+		Format(fmt.Sprintf("5/18/best[ext=mp4]/best[height<=%d]", height)).
+		NoPlaylist().
+		Print("urls")
+
+	return d.resolveUrl(dl, videoUrl)
+}
+
+// ConversionUrl gets the direct url of the smallest stream with audio & video that
+// has at least the given height, which fails if there is none, e.g. above 360p
+func (d *YtdlpDownloader) ConversionUrl(videoUrl string, height int) (string, error) {
+	dl := ytdlp.New().
+		Format(fmt.Sprintf("best[height>=%d][vcodec!=none][acodec!=none]", height)).
+		FormatSort("+res").
+		NoPlaylist().
+		Print("urls")
+
+	return d.resolveUrl(dl, videoUrl)
+}
+
+// resolveUrl runs yt-dlp and returns the first url it has printed
+func (d *YtdlpDownloader) resolveUrl(dl *ytdlp.Command, videoUrl string) (string, error) {
+	result, err := dl.Run(context.Background(), videoUrl)
+	if err != nil {
+		return "", fmt.Errorf("yt-dlp failed: %w", err)
+	}
+
+	url := strings.TrimSpace(result.Stdout)
+	if url == "" {
+		return "", fmt.Errorf("no url returned")
+	}
+
+	// yt-dlp may return multiple urls, take the first one
+	lines := strings.Split(url, "\n")
+	return lines[0], nil
+}
@@ -0,0 +1,116 @@
+package templates
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Overrides replaces the rendering of documents with templates from a folder, which are
+// named after the root element of the document, e.g. "feed.xml" or "entry.xml".
+// Templates are parsed again once they change, so the output can be tweaked without a restart.
+type Overrides struct {
+	Folder string
+
+	mu        sync.Mutex
+	templates map[string]*overrideTemplate
+}
+
+type overrideTemplate struct {
+	template *template.Template
+	modTime  time.Time
+}
+
+// Overrides of the rendered documents, disabled if nil
+var overrides *Overrides
+
+// SetOverrideFolder enables overriding documents with templates from the given folder,
+// where an empty folder disables overrides
+func SetOverrideFolder(folder string) {
+	if folder == "" {
+		overrides = nil
+		return
+	}
+	overrides = &Overrides{Folder: folder, templates: make(map[string]*overrideTemplate)}
+}
+
+// Functions available inside of override templates
+var overrideFuncs = template.FuncMap{
+	"xml": escapeXML,
+}
+
+// lookup returns the template for a document, or nil if it isn't overridden
+func (o *Overrides) lookup(document interface{}) (*template.Template, error) {
+	name := rootElementName(document)
+	if name == "" {
+		return nil, nil
+	}
+	name += ".xml"
+	path := filepath.Join(o.Folder, name)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		delete(o.templates, name)
+		return nil, nil
+	}
+
+	if cached, ok := o.templates[name]; ok && cached.modTime.Equal(stat.ModTime()) {
+		return cached.template, nil
+	}
+
+	parsed, err := template.New(name).Funcs(overrideFuncs).ParseFiles(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template %s: %w", name, err)
+	}
+	o.templates[name] = &overrideTemplate{template: parsed, modTime: stat.ModTime()}
+	return parsed, nil
+}
+
+// render executes the override template of a document, returning false if there is none
+func (o *Overrides) render(document interface{}) (string, bool, error) {
+	tmpl, err := o.lookup(document)
+	if err != nil || tmpl == nil {
+		return "", false, err
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, document); err != nil {
+		return "", false, fmt.Errorf("failed to execute template %s: %w", tmpl.Name(), err)
+	}
+	return Sanitize(buf.String()), true, nil
+}
+
+// rootElementName returns the element name of a document from its XMLName field
+func rootElementName(document interface{}) string {
+	documentType := reflect.TypeOf(document)
+	if documentType.Kind() == reflect.Pointer {
+		documentType = documentType.Elem()
+	}
+	if documentType.Kind() != reflect.Struct {
+		return ""
+	}
+
+	field, ok := documentType.FieldByName("XMLName")
+	if !ok {
+		return ""
+	}
+	name, _, _ := strings.Cut(field.Tag.Get("xml"), ",")
+	return name
+}
+
+// escapeXML escapes a value for use inside of xml text or attributes
+func escapeXML(value interface{}) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(Sanitize(fmt.Sprint(value))))
+	return buf.String()
+}
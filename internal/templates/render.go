@@ -1,40 +1,71 @@
 package templates
 
-import "bytes"
+import (
+	"encoding/xml"
+	"strings"
+	"unicode/utf8"
+)
 
-// RenderFeed renders the feed template with the given data
-func (t *Templates) RenderFeed(data interface{}) (string, error) {
-	var buf bytes.Buffer
-	if err := t.Feed.Execute(&buf, data); err != nil {
+// Render marshals a document into a well-formed xml string,
+// unless it's replaced by a template of the override folder
+func Render(document interface{}) (string, error) {
+	if overrides != nil {
+		if rendered, ok, err := overrides.render(document); ok || err != nil {
+			return rendered, err
+		}
+	}
+
+	data, err := xml.MarshalIndent(document, "", "    ")
+	if err != nil {
 		return "", err
 	}
-	return buf.String(), nil
+	return xml.Header + Sanitize(string(data)), nil
 }
 
-// RenderVideoEntry renders the video entry template with the given data
-func (t *Templates) RenderVideoEntry(data interface{}) (string, error) {
-	var buf bytes.Buffer
-	if err := t.VideoEntry.Execute(&buf, data); err != nil {
-		return "", err
+// Sanitize removes all characters that are not allowed in XML 1.0, as well as
+// invalid utf-8 sequences and their replacement characters. Characters outside
+// of the basic multilingual plane, e.g. emoji, are removed too, since the
+// xml parser of the Wii is unable to handle them.
+func Sanitize(s string) string {
+	if isSanitized(s) {
+		return s
+	}
+
+	var builder strings.Builder
+	builder.Grow(len(s))
+
+	for _, r := range s {
+		if isAllowedRune(r) {
+			builder.WriteRune(r)
+		}
 	}
-	return buf.String(), nil
+	return builder.String()
 }
 
-// RenderSuggestions renders the suggestions template with the given data
-func (t *Templates) RenderSuggestions(data interface{}) (string, error) {
-	var buf bytes.Buffer
-	if err := t.Suggestions.Execute(&buf, data); err != nil {
-		return "", err
+// isSanitized checks if a string only consists of allowed characters
+func isSanitized(s string) bool {
+	for _, r := range s {
+		if !isAllowedRune(r) {
+			return false
+		}
 	}
-	return buf.String(), nil
+	return true
 }
 
-// RenderError renders the error template with the given message
-func (t *Templates) RenderError(message string) (string, error) {
-	var buf bytes.Buffer
-	data := struct{ Message string }{Message: message}
-	if err := t.Error.Execute(&buf, data); err != nil {
-		return "", err
+// isAllowedRune checks if a character is part of the allowed character set,
+// i.e. #x9 | #xA | #xD | [#x20-#xD7FF] | [#xE000-#xFFFD], excluding U+FFFD
+func isAllowedRune(r rune) bool {
+	switch {
+	case r == utf8.RuneError:
+		// Invalid utf-8 sequences are decoded as the replacement character
+		return false
+	case r == 0x09 || r == 0x0A || r == 0x0D:
+		return true
+	case r >= 0x20 && r <= 0xD7FF:
+		return true
+	case r >= 0xE000 && r <= 0xFFFD:
+		return true
+	default:
+		return false
 	}
-	return buf.String(), nil
 }
@@ -1,66 +1,226 @@
 package templates
 
-import (
-	"html"
-	"strings"
-	"text/template"
+import "encoding/xml"
+
+// Namespaces used by the gdata documents
+const (
+	NamespaceAtom       = "http://www.w3.org/2005/Atom"
+	NamespaceOpenSearch = "http://a9.com/-/spec/opensearch/1.1/"
+	NamespaceMedia      = "http://search.yahoo.com/mrss/"
+	NamespaceGData      = "http://schemas.google.com/g/2005"
+	NamespaceYouTube    = "http://gdata.youtube.com/schemas/2007"
 )
 
-// Templates holds all parsed XML templates
-type Templates struct {
-	Feed        *template.Template
-	VideoEntry  *template.Template
-	Suggestions *template.Template
-	Error       *template.Template
-}
-
-// Global template instance
-var templates *Templates
-
-// templateFuncs provides custom functions for templates
-var templateFuncs = template.FuncMap{
-	"escape": func(s string) string {
-		return html.EscapeString(s)
-	},
-	"escapeAttr": func(s string) string {
-		s = strings.ReplaceAll(s, "&", "&amp;")
-		s = strings.ReplaceAll(s, "<", "&lt;")
-		s = strings.ReplaceAll(s, ">", "&gt;")
-		s = strings.ReplaceAll(s, "\"", "&quot;")
-		s = strings.ReplaceAll(s, "'", "&apos;")
-		return s
-	},
-}
-
-// Initialize initializes all XML templates from embedded files
-func Initialize(templatesDir string) error {
-	var err error
-	templates = &Templates{}
-
-	templates.Feed, err = template.New("feed.xml").Funcs(templateFuncs).ParseFiles(templatesDir + "/feed.xml")
-	if err != nil {
-		return err
-	}
+// Namespaces holds the namespace declarations of a root element
+type Namespaces struct {
+	Atom       string `xml:"xmlns,attr,omitempty"`
+	OpenSearch string `xml:"xmlns:openSearch,attr,omitempty"`
+	Media      string `xml:"xmlns:media,attr,omitempty"`
+	GData      string `xml:"xmlns:gd,attr,omitempty"`
+	YouTube    string `xml:"xmlns:yt,attr,omitempty"`
+}
 
-	templates.VideoEntry, err = template.New("video_entry.xml").Funcs(templateFuncs).ParseFiles(templatesDir + "/video_entry.xml")
-	if err != nil {
-		return err
+// NewNamespaces returns the namespace declarations used by feeds and entries
+func NewNamespaces(openSearch bool) Namespaces {
+	namespaces := Namespaces{
+		Atom:    NamespaceAtom,
+		Media:   NamespaceMedia,
+		GData:   NamespaceGData,
+		YouTube: NamespaceYouTube,
 	}
-
-	templates.Suggestions, err = template.New("suggestions.xml").Funcs(templateFuncs).ParseFiles(templatesDir + "/suggestions.xml")
-	if err != nil {
-		return err
+	if openSearch {
+		namespaces.OpenSearch = NamespaceOpenSearch
 	}
+	return namespaces
+}
 
-	templates.Error, err = template.New("error.xml").Funcs(templateFuncs).ParseFiles(templatesDir + "/error.xml")
-	if err != nil {
-		return err
-	}
+// Feed is the root element of an atom feed
+type Feed struct {
+	XMLName xml.Name `xml:"feed"`
+	Namespaces
+
+	ID           string     `xml:"id"`
+	Updated      string     `xml:"updated"`
+	Category     []Category `xml:"category"`
+	Title        TypedText  `xml:"title"`
+	Logo         string     `xml:"logo,omitempty"`
+	Links        []Link     `xml:"link"`
+	Author       []Author   `xml:"author"`
+	Generator    *Generator `xml:"generator,omitempty"`
+	TotalResults int        `xml:"openSearch:totalResults"`
+	StartIndex   int        `xml:"openSearch:startIndex"`
+	ItemsPerPage int        `xml:"openSearch:itemsPerPage"`
+	Entries      []Entry    `xml:"entry"`
+}
+
+// Entry is a single video, channel or playlist entry
+type Entry struct {
+	XMLName xml.Name `xml:"entry"`
+	Namespaces
+
+	ID                string             `xml:"id"`
+	Published         string             `xml:"published,omitempty"`
+	Updated           string             `xml:"updated"`
+	Category          []Category         `xml:"category"`
+	Title             string             `xml:"title"`
+	Content           *TypedText         `xml:"content,omitempty"`
+	Summary           *string            `xml:"summary,omitempty"`
+	Links             []Link             `xml:"link"`
+	Author            []Author           `xml:"author"`
+	FeedLinks         []FeedLink         `xml:"gd:feedLink,omitempty"`
+	MediaThumbnail    *Thumbnail         `xml:"media:thumbnail,omitempty"`
+	MediaGroup        *MediaGroup        `xml:"media:group,omitempty"`
+	Rating            *Rating            `xml:"gd:rating,omitempty"`
+	Statistics        *Statistics        `xml:"yt:statistics,omitempty"`
+	YouTubeRating     *YouTubeRating     `xml:"yt:rating,omitempty"`
+	ChannelStatistics *ChannelStatistics `xml:"yt:channelStatistics,omitempty"`
+	ChannelID         string             `xml:"yt:channelId,omitempty"`
+	PlaylistID        string             `xml:"yt:playlistId,omitempty"`
+}
+
+// TypedText is an element with text content and a content type, e.g. "text" or "plain"
+type TypedText struct {
+	Type  string `xml:"type,attr,omitempty"`
+	Value string `xml:",chardata"`
+}
+
+type Category struct {
+	Scheme string `xml:"scheme,attr"`
+	Term   string `xml:"term,attr"`
+	Label  string `xml:"label,attr,omitempty"`
+}
+
+type Link struct {
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr"`
+	Href string `xml:"href,attr"`
+}
+
+type Author struct {
+	Name   string `xml:"name"`
+	URI    string `xml:"uri"`
+	UserID string `xml:"yt:userId,omitempty"`
+}
+
+type Generator struct {
+	Version string `xml:"version,attr"`
+	URI     string `xml:"uri,attr"`
+	Name    string `xml:",chardata"`
+}
+
+type FeedLink struct {
+	Rel       string `xml:"rel,attr"`
+	Href      string `xml:"href,attr"`
+	CountHint int64  `xml:"countHint,attr"`
+}
+
+type MediaGroup struct {
+	Category    []MediaCategory `xml:"media:category,omitempty"`
+	Content     []MediaContent  `xml:"media:content,omitempty"`
+	Credit      *MediaCredit    `xml:"media:credit,omitempty"`
+	Description *TypedText      `xml:"media:description,omitempty"`
+	Keywords    *string         `xml:"media:keywords,omitempty"`
+	Player      *MediaPlayer    `xml:"media:player,omitempty"`
+	Thumbnail   []Thumbnail     `xml:"media:thumbnail"`
+	Title       *TypedText      `xml:"media:title,omitempty"`
+	Duration    *Duration       `xml:"yt:duration,omitempty"`
+	Uploaded    string          `xml:"yt:uploaded,omitempty"`
+	UploaderID  string          `xml:"yt:uploaderId,omitempty"`
+	VideoID     string          `xml:"yt:videoid,omitempty"`
+}
+
+type MediaCategory struct {
+	Label  string `xml:"label,attr"`
+	Scheme string `xml:"scheme,attr"`
+	Value  string `xml:",chardata"`
+}
+
+type MediaContent struct {
+	URL        string `xml:"url,attr"`
+	Type       string `xml:"type,attr"`
+	Medium     string `xml:"medium,attr"`
+	IsDefault  bool   `xml:"isDefault,attr,omitempty"`
+	Expression string `xml:"expression,attr"`
+	Duration   int    `xml:"duration,attr"`
+	Format     int    `xml:"yt:format,attr"`
+}
+
+type MediaPlayer struct {
+	URL string `xml:"url,attr"`
+}
+
+type MediaCredit struct {
+	Role    string `xml:"role,attr"`
+	Scheme  string `xml:"scheme,attr"`
+	Display string `xml:"yt:display,attr"`
+	Value   string `xml:",chardata"`
+}
+
+type Thumbnail struct {
+	Name   string `xml:"yt:name,attr,omitempty"`
+	URL    string `xml:"url,attr"`
+	Width  int    `xml:"width,attr,omitempty"`
+	Height int    `xml:"height,attr,omitempty"`
+}
+
+type Duration struct {
+	Seconds int `xml:"seconds,attr"`
+}
+
+type Statistics struct {
+	FavoriteCount int64  `xml:"favoriteCount,attr"`
+	ViewCount     int64  `xml:"viewCount,attr"`
+	LikeCount     *int64 `xml:"likeCount,attr,omitempty"`
+}
+
+type Rating struct {
+	Average   float64 `xml:"average,attr"`
+	Max       int     `xml:"max,attr"`
+	Min       int     `xml:"min,attr"`
+	NumRaters int64   `xml:"numRaters,attr"`
+	Rel       string  `xml:"rel,attr"`
+}
+
+type YouTubeRating struct {
+	NumLikes    int64 `xml:"numLikes,attr"`
+	NumDislikes int64 `xml:"numDislikes,attr"`
+}
+
+type ChannelStatistics struct {
+	SubscriberCount int64 `xml:"subscriberCount,attr"`
+	VideoCount      int64 `xml:"videoCount,attr"`
+}
+
+// Suggestions is the root element of a search suggestions response
+type Suggestions struct {
+	XMLName            xml.Name           `xml:"toplevel"`
+	CompleteSuggestion CompleteSuggestion `xml:"CompleteSuggestion"`
+}
+
+type CompleteSuggestion struct {
+	Query       string       `xml:"query,attr"`
+	Suggestions []Suggestion `xml:"suggestion"`
+}
+
+type Suggestion struct {
+	Data string `xml:"data,attr"`
+}
+
+// Errors is the root element of an error response
+type Errors struct {
+	XMLName   xml.Name `xml:"errors"`
+	Namespace string   `xml:"xmlns,attr"`
+	Errors    []Error  `xml:"error"`
+}
 
-	return nil
+type Error struct {
+	Domain         string         `xml:"domain"`
+	Code           string         `xml:"code"`
+	Location       *ErrorLocation `xml:"location,omitempty"`
+	InternalReason string         `xml:"internalReason"`
 }
 
-// GetInstance returns the global templates instance
-func GetInstance() *Templates {
-	return templates
+type ErrorLocation struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
 }